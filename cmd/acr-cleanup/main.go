@@ -1,7 +1,10 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,7 +12,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,15 +25,23 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/equinor/radix-acr-cleanup/pkg/aad"
 	"github.com/equinor/radix-acr-cleanup/pkg/acr"
+	"github.com/equinor/radix-acr-cleanup/pkg/cache"
 	"github.com/equinor/radix-acr-cleanup/pkg/image"
 	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/equinor/radix-acr-cleanup/pkg/oci"
+	"github.com/equinor/radix-acr-cleanup/pkg/policy"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+	"github.com/equinor/radix-acr-cleanup/pkg/retry"
+	"github.com/equinor/radix-acr-cleanup/pkg/semver"
+	"github.com/equinor/radix-acr-cleanup/pkg/usage"
 	"github.com/equinor/radix-common/utils/delaytick"
 	"github.com/equinor/radix-common/utils/timewindow"
 	"github.com/equinor/radix-operator/pkg/apis/kube"
 	radixclient "github.com/equinor/radix-operator/pkg/client/clientset/versioned"
 	"github.com/spf13/pflag"
-	corev1 "k8s.io/api/core/v1"
+	"golang.org/x/time/rate"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -35,54 +49,296 @@ import (
 
 const (
 	timezone            = "Local"
+	registryLabel       = "registry"
 	clusterTypeLabel    = "clusterType"
 	repositoryLabel     = "repository"
 	isTaggedLabel       = "tagged"
+	methodLabel         = "method"
+	reasonLabel         = "reason"
 	manifestGracePeriod = 2 * time.Hour
+	windowCheckInterval = time.Minute
+	registryTypeACR     = "acr"
+	registryTypeOCI     = "oci"
+	// Stores of the repository cache
+	cacheStoreFile      = "file"
+	cacheStoreConfigMap = "configmap"
+	cacheSaveTimeout    = 30 * time.Second
+	// Ways of getting AAD tokens for acr
+	authMethodClientSecret      = "client-secret"
+	authMethodClientCertificate = "client-certificate"
+	authMethodWorkloadIdentity  = "workload-identity"
+	authMethodManagedIdentity   = "managed-identity"
+	// Time allowed for in-flight metrics requests when shutting down
+	serverShutdownTimeout = 5 * time.Second
+)
+
+var errWindowClosed = errors.New("cleanup window closed")
+
+// retainReason Why a manifest is retained, used as reason label of radix_acr_images_retained
+type retainReason string
+
+const (
+	retainedWithinGracePeriod   retainReason = "grace_period"
+	retainedUntaggedNotMandated retainReason = "untagged"
+	retainedLatestUntagged      retainReason = "latest_untagged"
+	retainedLatestTagged        retainReason = "latest_tagged"
+	retainedOtherClusterType    retainReason = "other_cluster_type"
+	retainedInUse               retainReason = "in_use"
+	retainedLocked              retainReason = "locked"
+	retainedIndexChild          retainReason = "index_child"
+	retainedReferrer            retainReason = "referrer"
+	retainedProtectedTag        retainReason = "protected_tag"
+	retainedReleaseTag          retainReason = "release_tag"
+)
+
+// verdict Outcome of evaluating a manifest
+type verdict int
+
+const (
+	verdictRetain verdict = iota
+	verdictDelete
+	// verdictUntag Remove only the tags of the current cluster type, as the manifest is shared with other cluster types
+	verdictUntag
+)
+
+// matchStrategy How an image in the cluster was matched with a manifest
+type matchStrategy string
+
+const (
+	matchedNone   matchStrategy = "none"
+	matchedDigest matchStrategy = "digest"
+	matchedTag    matchStrategy = "tag"
+)
+
+// heldManifest A manifest tagged for the cluster type, held back until it is known not to be among the latest.
+// position is the number of manifests tagged for the cluster type seen up to and including it
+type heldManifest struct {
+	manifest manifest.Data
+	verdict  verdict
+	position int
+}
+
+// manifestKind Decides which counter a deleted manifest is added to
+type manifestKind int
+
+const (
+	taggedManifest manifestKind = iota
+	untaggedManifest
+	referrerManifest
 )
 
 var nrImagesDeleted = promauto.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "radix_acr_images_deleted",
 		Help: "The total number of image manifests deleted",
-	}, []string{clusterTypeLabel, repositoryLabel, isTaggedLabel})
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel, isTaggedLabel})
+
+var nrReferrersDeleted = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_referrers_deleted",
+		Help: "The total number of referrers, such as signatures and SBOMs, deleted together with their subject",
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel})
+
+var nrTagsRemoved = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_tags_removed",
+		Help: "The total number of cluster type tags removed from manifests shared with other cluster types",
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel})
+
+var nrUntagErrors = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_untag_errors",
+		Help: "The total number of tag removal errors",
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel, reasonLabel})
 
 var nrImagesRetained = promauto.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "radix_acr_images_retained",
 		Help: "The total number of image manifests retained",
-	}, []string{clusterTypeLabel, repositoryLabel, isTaggedLabel})
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel, isTaggedLabel, reasonLabel})
+
+var nrRepositoriesLocked = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_repositories_locked",
+		Help: "The total number of repositories skipped as they are locked",
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel})
+
+var nrRepositoriesDeleted = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_repositories_deleted",
+		Help: "The total number of repositories deleted as they were left without manifests",
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel})
+
+var nrRepositoriesSkipped = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_repositories_skipped",
+		Help: "The total number of repositories skipped as they are unchanged since they were last evaluated",
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel})
+
+var nrCacheHits = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_cache_hits",
+		Help: "The total number of repositories found unchanged in the cache",
+	}, []string{registryLabel, clusterTypeLabel})
+
+var nrCacheMisses = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_cache_misses",
+		Help: "The total number of repositories not found in the cache, or changed since they were last evaluated",
+	}, []string{registryLabel, clusterTypeLabel})
+
+var nrForeignReferencesIgnored = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_foreign_references_ignored",
+		Help: "The total number of images in the cluster ignored as they reference other registries",
+	}, []string{registryLabel, clusterTypeLabel})
 
 var nrImagesDeleteErrors = promauto.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "radix_acr_image_delete_errors",
 		Help: "The total number of image manifest delete errors",
-	}, []string{clusterTypeLabel, repositoryLabel})
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel, reasonLabel})
 
 var nrListManifestErrors = promauto.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "radix_acr_list_manifest_errors",
 		Help: "The total number of manifest list request errors",
-	}, []string{clusterTypeLabel, repositoryLabel})
+	}, []string{registryLabel, clusterTypeLabel, repositoryLabel, reasonLabel})
+
+// cleanupOptions Settings controlling which manifests are deleted
+type cleanupOptions struct {
+	// registryName Name of the registry, used in logs and as registry label of metrics
+	registryName string
+	// loginServer Host images in the registry are referenced by. Empty means images of any host are matched with the registry
+	loginServer string
+	// registryAliases Other hosts, such as mirrors, referencing the registry
+	registryAliases []string
+	clusterType     string
+	// classifier Classifies tags by the known cluster types and the tag rules. Nil means manifest.DefaultClusterTypes and no rules
+	classifier           *manifest.Classifier
+	deleteUntagged       bool
+	retainLatestUntagged int
+	// retainLatestTagged Number of the most recent manifests tagged for the cluster type retained per repository, whether in use or not
+	retainLatestTagged int
+	performDelete      bool
+	whitelisted        []string
+	// releaseRetention Retains the newest semantic versions of release tags in matching repositories, deleting the rest
+	releaseRetention policy.ReleaseRetentions
+	// untagShared Remove only the tags of the current cluster type from manifests also tagged for other cluster types
+	untagShared bool
+	// deleteEmptyRepositories Delete repositories left without manifests, unless updated within emptyRepositoryMinAge
+	deleteEmptyRepositories bool
+	emptyRepositoryMinAge   time.Duration
+	// discoverReferrers Look up referrers of every manifest through the referrers API
+	discoverReferrers bool
+	// concurrency Number of repositories processed at the same time
+	concurrency int
+	// cache Skips repositories unchanged since they were last evaluated. Nil means every repository is evaluated
+	cache *cache.Cache
+	// deleteLimiter Shared by all workers to limit the rate of delete requests. Nil means no limit
+	deleteLimiter *rate.Limiter
+}
+
+// registryCleanup A registry and the settings it is cleaned with
+type registryCleanup struct {
+	registry registry.Registry
+	options  cleanupOptions
+}
+
+// registriesToClean Returns the registries given as flag, followed by the registries only listed in the policy
+func registriesToClean(registryNames []string, cleanupPolicy *policy.Policy) []string {
+	registries := make([]string, 0, len(registryNames))
+	for _, registryName := range registryNames {
+		if registryName = strings.TrimSpace(registryName); len(registryName) > 0 && !slices.Contains(registries, registryName) {
+			registries = append(registries, registryName)
+		}
+	}
+	if cleanupPolicy != nil {
+		for _, registryPolicy := range cleanupPolicy.Registries {
+			if !slices.Contains(registries, registryPolicy.Name) {
+				registries = append(registries, registryPolicy.Name)
+			}
+		}
+	}
+	return registries
+}
+
+// applyPolicy Returns options with the settings of a registry policy replacing the ones given as flags
+func applyPolicy(options cleanupOptions, registryPolicy policy.Registry) cleanupOptions {
+	if registryPolicy.Whitelisted != nil {
+		options.whitelisted = registryPolicy.Whitelisted
+	}
+	if registryPolicy.DeleteUntagged != nil {
+		options.deleteUntagged = *registryPolicy.DeleteUntagged
+	}
+	if registryPolicy.RetainLatestUntagged != nil {
+		options.retainLatestUntagged = *registryPolicy.RetainLatestUntagged
+	}
+	if registryPolicy.RetainLatestTagged != nil {
+		options.retainLatestTagged = *registryPolicy.RetainLatestTagged
+	}
+	if registryPolicy.Aliases != nil {
+		options.registryAliases = registryPolicy.Aliases
+	}
+	return options
+}
+
+var nrRequestRetries = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radix_acr_request_retries",
+		Help: "The total number of retried registry and token requests",
+	}, []string{registryLabel, methodLabel, reasonLabel})
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGTERM)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
 	fs := initializeFlagSet()
 
 	var (
 		period               = fs.Duration("period", time.Minute*60, "Interval between checks")
-		registry             = fs.String("registry", "", "Name of the ACR registry (Required)")
-		clusterType          = fs.String("cluster-type", "", "Type of cluster (Required)")
+		registryNames        = fs.StringSlice("registry", []string{}, "Names of the ACR registries, or hosts of the OCI registries (Required unless listed in the policy file)")
+		policyFile           = fs.String("policy-file", "", "Path to YAML file with whitelist and untagged settings per registry, overriding the flags")
+		registryType         = fs.String("registry-type", registryTypeACR, "Type of registry, options: 'acr', 'oci'")
+		registryUsername     = fs.String("registry-username", "", "Username for basic and bearer token auth to an OCI registry")
+		registryPasswordFile = fs.String("registry-password-file", "", "Path to file with password for basic and bearer token auth to an OCI registry")
+		registryPlainHTTP    = fs.Bool("registry-plain-http", false, "Use http instead of https when talking to an OCI registry")
+		registryAliases      = fs.StringSlice("registry-aliases", []string{}, "Other hosts, such as mirrors, images in the registry are referenced by")
+		requestTimeout       = fs.Duration("request-timeout", time.Minute, "Timeout of each registry and token request including retries, and reading a listing page")
+		retryMaxAttempts     = fs.Int("retry-max-attempts", retry.DefaultPolicy().MaxAttempts, "Number of attempts of registry and token requests failing with throttling, 5xx or network errors")
+		retryInitialBackoff  = fs.Duration("retry-initial-backoff", retry.DefaultPolicy().InitialBackoff, "Wait before the first retry, doubled for each following retry")
+		retryMaxBackoff      = fs.Duration("retry-max-backoff", retry.DefaultPolicy().MaxBackoff, "Maximum wait between retries, also limiting Retry-After")
+		retryJitter          = fs.Float64("retry-jitter", retry.DefaultPolicy().Jitter, "Fraction of the backoff randomly added or subtracted")
+		clusterType          = fs.String("cluster-type", "", "Type of cluster, one of the cluster types (Required)")
+		clusterTypeSpecs     = fs.StringSlice("cluster-types", []string{"development", "production", "playground"}, "Known cluster types, as name for tags prefixed name-, or name=prefix|prefix. Manifests not tagged for any of them are untagged")
 		activeClusterName    = fs.String("active-cluster-name", "", "Name of the active cluster (Required)")
 		deleteUntagged       = fs.Bool("delete-untagged", false, "Solution can delete untagged images")
 		retainLatestUntagged = fs.Int("retain-latest-untagged", 5, "Solution can retain x number of untagged images if set to delete")
+		retainLatestTagged   = fs.Int("retain-latest-tagged", 0, "Retain the x most recent images tagged for the cluster type in each repository, also when not in use")
 		performDelete        = fs.Bool("perform-delete", false, "Can control that the solution can actually delete manifest")
+		untagShared          = fs.Bool("untag-shared", false, "Remove only the tags of the cluster type from manifests also tagged for other cluster types, instead of deleting them")
+		deleteEmptyRepos     = fs.Bool("delete-empty-repositories", false, "Delete repositories left without manifests after cleanup")
+		emptyRepoMinAge      = fs.Duration("empty-repository-min-age", 24*time.Hour, "Retain empty repositories updated more recently than this")
 		cleanupDays          = fs.StringSlice("cleanup-days", timewindow.EveryDay, "Schedule cleanup on these days")
 		cleanupStart         = fs.String("cleanup-start", "0:00", "Start time")
 		cleanupEnd           = fs.String("cleanup-end", "6:00", "End time")
 		whitelisted          = fs.StringSlice("whitelisted", []string{}, "Lists repositories which are whitelisted")
+		usageSourceNames     = fs.StringSlice("usage-sources", usage.DefaultNames, "Kinds of objects in the cluster whose images are in use, options: '"+strings.Join(usage.Names, "', '")+"'")
+		discoverReferrers    = fs.Bool("discover-referrers", false, "Look up signatures, SBOMs and other referrers of each manifest through the referrers API, and delete them only together with their subject")
+		concurrency          = fs.Int("concurrency", 4, "Number of repositories listed and evaluated in parallel")
+		deleteRate           = fs.Float64("delete-rate", 10, "Maximum number of delete requests per second across all repositories, 0 for no limit")
+		authMethod           = fs.String("auth-method", authMethodClientSecret, "How to get AAD tokens for acr, options: 'client-secret', 'client-certificate', 'workload-identity', 'managed-identity'")
+		cacheStore           = fs.String("cache", "", "Skip repositories unchanged since they were last evaluated, with state stored in a 'file' or 'configmap'. Empty disables the cache")
+		cacheFile            = fs.String("cache-file", "/app/cache/cache.json", "Path of the cache file")
+		cacheConfigMap       = fs.String("cache-configmap", "radix-acr-cleanup-cache", "Name of the cache configmap")
+		cacheNamespace       = fs.String("cache-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the cache configmap")
+		cacheMaxAge          = fs.Duration("cache-max-age", 24*time.Hour, "Evaluate unchanged repositories again after this long")
+		tenantID             = fs.String("azure-tenant-id", os.Getenv("AZURE_TENANT_ID"), "Azure tenant of the identity (Required for acr, except with managed-identity)")
+		clientID             = fs.String("azure-client-id", os.Getenv("AZURE_CLIENT_ID"), "Client id of the identity (Required for client-certificate and workload-identity)")
+		credentialsFile      = fs.String("azure-credentials-file", "", "Path to JSON file with service principal id and password (Required for client-secret)")
+		certificateFile      = fs.String("azure-client-certificate-file", "", "Path to PEM file with certificate and private key (Required for client-certificate)")
+		federatedTokenFile   = fs.String("azure-federated-token-file", os.Getenv("AZURE_FEDERATED_TOKEN_FILE"), "Path to the service account token file (Required for workload-identity)")
+		authorityHost        = fs.String("azure-authority-host", cmp.Or(os.Getenv("AZURE_AUTHORITY_HOST"), aad.DefaultAuthorityHost), "Microsoft Entra ID authority")
 		prettyPrint          = fs.Bool("pretty-print", false, "Use colored text instead of json for log output")
 		logLevel             = fs.String("log-level", "info", "Set log level for output, defaults to 'info', options: 'debug', 'info', 'warn', 'error'")
 	)
@@ -93,7 +349,17 @@ func main() {
 		return s == nil || len(strings.TrimSpace(*s)) == 0
 	}
 
-	if stringIsNilOrEmpty(registry) || stringIsNilOrEmpty(clusterType) || stringIsNilOrEmpty(activeClusterName) {
+	var cleanupPolicy *policy.Policy
+	if !stringIsNilOrEmpty(policyFile) {
+		var err error
+		if cleanupPolicy, err = policy.ReadFile(*policyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	registries := registriesToClean(*registryNames, cleanupPolicy)
+
+	if len(registries) == 0 || stringIsNilOrEmpty(clusterType) || stringIsNilOrEmpty(activeClusterName) {
 		flag.PrintDefaults()
 		<-ctx.Done()
 		os.Exit(1)
@@ -104,17 +370,142 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to initialize Zerolog")
 	}
 
+	clusterTypes, err := manifest.ParseClusterTypes(*clusterTypeSpecs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid cluster types")
+	}
+	if !clusterTypes.Contains(*clusterType) {
+		log.Fatal().Msgf("Cluster type %s is not one of the cluster types %s", *clusterType, clusterTypes)
+	}
+	classifier, err := manifest.NewClassifier(clusterTypes, cleanupPolicy.Rules())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid tag rules")
+	}
+
 	log.Info().Msgf("Cleanup days: %s", *cleanupDays)
 	log.Info().Msgf("Cleanup start: %s", *cleanupStart)
 	log.Info().Msgf("Cleanup end: %s", *cleanupEnd)
 	log.Info().Msgf("Period: %s", *period)
-	log.Info().Msgf("Registry: %s", *registry)
+	log.Info().Msgf("Registries: %s", registries)
+	log.Info().Msgf("Policy file: %s", *policyFile)
+	log.Info().Msgf("Registry type: %s", *registryType)
+	log.Info().Msgf("Registry aliases: %s", *registryAliases)
+	log.Info().Msgf("Request timeout: %s", *requestTimeout)
+	log.Info().Msgf("Retry max attempts: %d, backoff: %s-%s, jitter: %.2f", *retryMaxAttempts, *retryInitialBackoff, *retryMaxBackoff, *retryJitter)
 	log.Info().Msgf("Clustertype: %s", *clusterType)
+	log.Info().Msgf("Tag classification: %s", classifier)
 	log.Info().Msgf("Active cluster name: %s", *activeClusterName)
 	log.Info().Msgf("Delete untagged: %t", *deleteUntagged)
 	log.Info().Msgf("Retain untagged: %d", *retainLatestUntagged)
+	log.Info().Msgf("Retain tagged: %d", *retainLatestTagged)
 	log.Info().Msgf("Perform delete: %t", *performDelete)
+	log.Info().Msgf("Untag shared: %t", *untagShared)
+	log.Info().Msgf("Delete empty repositories: %t, min age: %s", *deleteEmptyRepos, *emptyRepoMinAge)
 	log.Info().Msgf("Whitelisted: %s", *whitelisted)
+	log.Info().Msgf("Discover referrers: %t", *discoverReferrers)
+	log.Info().Msgf("Concurrency: %d", *concurrency)
+	log.Info().Msgf("Cache: %s, max age: %s", *cacheStore, *cacheMaxAge)
+	log.Info().Msgf("Delete rate: %.2f/s", *deleteRate)
+
+	retryPolicy := retry.Policy{
+		MaxAttempts:    *retryMaxAttempts,
+		InitialBackoff: *retryInitialBackoff,
+		MaxBackoff:     *retryMaxBackoff,
+		Jitter:         *retryJitter,
+	}
+
+	auth := azureAuth{
+		method:             *authMethod,
+		tenantID:           *tenantID,
+		clientID:           *clientID,
+		credentialsFile:    *credentialsFile,
+		certificateFile:    *certificateFile,
+		federatedTokenFile: *federatedTokenFile,
+		authorityHost:      *authorityHost,
+	}
+	var registryPassword string
+	switch *registryType {
+	case registryTypeACR:
+		log.Info().Msgf("Auth method: %s", *authMethod)
+	case registryTypeOCI:
+		if *deleteEmptyRepos {
+			log.Fatal().Msg("Deleting empty repositories is not supported for oci registries")
+		}
+		if len(*registryUsername) > 0 {
+			password, err := os.ReadFile(*registryPasswordFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to read registry password")
+			}
+			registryPassword = strings.TrimSpace(string(password))
+		}
+	default:
+		log.Fatal().Msgf("Unknown registry type %s", *registryType)
+	}
+
+	defaultOptions := cleanupOptions{
+		clusterType:             *clusterType,
+		classifier:              classifier,
+		deleteUntagged:          *deleteUntagged,
+		retainLatestUntagged:    *retainLatestUntagged,
+		retainLatestTagged:      *retainLatestTagged,
+		performDelete:           *performDelete,
+		untagShared:             *untagShared,
+		whitelisted:             *whitelisted,
+		registryAliases:         *registryAliases,
+		releaseRetention:        cleanupPolicy.Retention(),
+		deleteEmptyRepositories: *deleteEmptyRepos,
+		emptyRepositoryMinAge:   *emptyRepoMinAge,
+		discoverReferrers:       *discoverReferrers,
+		concurrency:             *concurrency,
+	}
+
+	cleanups := make([]registryCleanup, 0, len(registries))
+	var credentials []aad.Credential
+	for _, registryName := range registries {
+		retryTransport := retry.NewTransport(http.DefaultTransport, retryPolicy)
+		retryTransport.OnRetry = func(req *http.Request, attempt int, reason string) {
+			log.Warn().Str("registry", registryName).Str("method", req.Method).Str("path", req.URL.Path).Int("attempt", attempt).Str("reason", reason).Msg("Retrying request")
+			addRequestRetry(registryName, req.Method, reason)
+		}
+		httpClient := &http.Client{Timeout: *requestTimeout, Transport: retryTransport}
+
+		var reg registry.Registry
+		loginServer := registryName
+		switch *registryType {
+		case registryTypeACR:
+			loginServer = acr.LoginServer(registryName)
+			credential, err := newAzureCredential(auth, httpClient)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to set up Azure credential")
+			}
+			credentials = append(credentials, credential)
+			reg = acr.NewClient(loginServer, credential, acr.WithTenantID(*tenantID), acr.WithHTTPClient(httpClient))
+		case registryTypeOCI:
+			options := []oci.Option{oci.WithHTTPClient(httpClient)}
+			if len(*registryUsername) > 0 {
+				options = append(options, oci.WithCredentials(*registryUsername, registryPassword))
+			}
+			if *registryPlainHTTP {
+				options = append(options, oci.WithPlainHTTP())
+			}
+			reg = oci.NewClient(registryName, options...)
+		}
+
+		options := defaultOptions
+		options.registryName = registryName
+		options.loginServer = loginServer
+		if registryPolicy, ok := cleanupPolicy.Registry(registryName); ok {
+			options = applyPolicy(options, registryPolicy)
+		}
+		// Each registry has its own rate limit, as registries are cleaned one after the other
+		if *deleteRate > 0 {
+			options.deleteLimiter = rate.NewLimiter(rate.Limit(*deleteRate), 1)
+		}
+		log.Info().Str("registry", registryName).Msgf("Login server: %s, aliases: %s, whitelisted: %s, delete untagged: %t, retain untagged: %d, retain tagged: %d",
+			options.loginServer, options.registryAliases, options.whitelisted, options.deleteUntagged, options.retainLatestUntagged, options.retainLatestTagged)
+
+		cleanups = append(cleanups, registryCleanup{registry: reg, options: options})
+	}
 
 	kubeClient, radixClient := getKubernetesClient()
 	kubeutil, err := kube.New(kubeClient, radixClient, nil, nil)
@@ -122,17 +513,134 @@ func main() {
 		panic(err)
 	}
 
-	go maintainImages(ctx, kubeutil, *cleanupDays, *cleanupStart, *cleanupEnd, *period,
-		*registry, *clusterType, *activeClusterName, *deleteUntagged, *retainLatestUntagged, *performDelete, *whitelisted)
+	var repositoryCache *cache.Cache
+	switch *cacheStore {
+	case "":
+	case cacheStoreFile:
+		repositoryCache = cache.New(cache.FileStore{Path: *cacheFile}, *cacheMaxAge)
+	case cacheStoreConfigMap:
+		if stringIsNilOrEmpty(cacheNamespace) {
+			log.Fatal().Msg("--cache-namespace is required with the configmap cache")
+		}
+		repositoryCache = cache.New(cache.ConfigMapStore{Client: kubeClient, Namespace: *cacheNamespace, Name: *cacheConfigMap}, *cacheMaxAge)
+	default:
+		log.Fatal().Msgf("Unknown cache %s", *cacheStore)
+	}
+
+	if len(*usageSourceNames) == 0 {
+		log.Fatal().Msg("--usage-sources requires at least one source")
+	}
+	usageSources := make([]usage.Source, 0, len(*usageSourceNames))
+	for _, name := range *usageSourceNames {
+		source, err := usage.New(strings.TrimSpace(name), kubeClient, radixClient)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid --usage-sources")
+		}
+		usageSources = append(usageSources, source)
+	}
+	log.Info().Msgf("Usage sources: %s", *usageSourceNames)
+
+	go maintainImages(ctx, kubeutil, usageSources, *cleanupDays, *cleanupStart, *cleanupEnd, *period,
+		cleanups, repositoryCache, *activeClusterName)
 
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/readyz", readinessHandler(credentials))
+	server := &http.Server{Addr: ":8080"}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to shut down server")
+		}
+	}()
+
 	log.Info().Msg("API is serving on port :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal().Err(err).Msg("Server exited unexpectedly")
 	}
 	<-ctx.Done()
 }
 
+// azureAuth Settings for getting AAD tokens for acr
+type azureAuth struct {
+	method             string
+	tenantID           string
+	clientID           string
+	credentialsFile    string
+	certificateFile    string
+	federatedTokenFile string
+	authorityHost      string
+}
+
+// newAzureCredential Returns a credential for the auth method, reusing tokens until they are about to expire
+func newAzureCredential(auth azureAuth, httpClient *http.Client) (aad.Credential, error) {
+	type flagValue struct{ flag, value string }
+	required := func(values ...flagValue) error {
+		for _, v := range values {
+			if len(strings.TrimSpace(v.value)) == 0 {
+				return fmt.Errorf("--%s is required with auth method %s", v.flag, auth.method)
+			}
+		}
+		return nil
+	}
+
+	switch auth.method {
+	case authMethodClientSecret:
+		if err := required(flagValue{"azure-tenant-id", auth.tenantID}, flagValue{"azure-credentials-file", auth.credentialsFile}); err != nil {
+			return nil, err
+		}
+		servicePrincipal, err := aad.ReadServicePrincipalFile(auth.credentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		credential := aad.NewClientSecretCredential(auth.tenantID, servicePrincipal.ID, servicePrincipal.Password)
+		credential.AuthorityHost = auth.authorityHost
+		credential.HTTPClient = httpClient
+		return aad.NewCachingCredential(credential), nil
+	case authMethodClientCertificate:
+		if err := required(flagValue{"azure-tenant-id", auth.tenantID}, flagValue{"azure-client-id", auth.clientID}, flagValue{"azure-client-certificate-file", auth.certificateFile}); err != nil {
+			return nil, err
+		}
+		certificate, privateKey, err := aad.ReadCertificateFile(auth.certificateFile)
+		if err != nil {
+			return nil, err
+		}
+		credential := aad.NewClientCertificateCredential(auth.tenantID, auth.clientID, certificate, privateKey)
+		credential.AuthorityHost = auth.authorityHost
+		credential.HTTPClient = httpClient
+		return aad.NewCachingCredential(credential), nil
+	case authMethodWorkloadIdentity:
+		if err := required(flagValue{"azure-tenant-id", auth.tenantID}, flagValue{"azure-client-id", auth.clientID}, flagValue{"azure-federated-token-file", auth.federatedTokenFile}); err != nil {
+			return nil, err
+		}
+		credential := aad.NewWorkloadIdentityCredential(auth.tenantID, auth.clientID, auth.federatedTokenFile)
+		credential.AuthorityHost = auth.authorityHost
+		credential.HTTPClient = httpClient
+		return aad.NewCachingCredential(credential), nil
+	case authMethodManagedIdentity:
+		credential := aad.NewManagedIdentityCredential(auth.clientID)
+		credential.HTTPClient = httpClient
+		return aad.NewCachingCredential(credential), nil
+	default:
+		return nil, fmt.Errorf("unknown auth method %s", auth.method)
+	}
+}
+
+// readinessHandler Reports not ready while any credential fails to get a token, e.g. as its client secret has expired
+func readinessHandler(credentials []aad.Credential) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, credential := range credentials {
+			if _, err := credential.GetToken(r.Context()); err != nil {
+				log.Warn().Err(err).Msg("Not ready, as getting an AAD token failed")
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
 func initZerologger(ctx context.Context, logLevel string, prettyPrint bool) (context.Context, error) {
 	if logLevel == "" {
 		logLevel = "info"
@@ -151,7 +659,7 @@ func initZerologger(ctx context.Context, logLevel string, prettyPrint bool) (con
 	return ctx, nil
 }
 
-func maintainImages(ctx context.Context, kubeutil *kube.Kube, cleanupDays []string, cleanupStart, cleanupEnd string, period time.Duration, registry, clusterType, activeClusterName string, deleteUntagged bool, retainLatestUntagged int, performDelete bool, whitelisted []string) {
+func maintainImages(ctx context.Context, kubeutil *kube.Kube, usageSources []usage.Source, cleanupDays []string, cleanupStart, cleanupEnd string, period time.Duration, cleanups []registryCleanup, repositoryCache *cache.Cache, activeClusterName string) {
 	window, err := timewindow.New(cleanupDays, cleanupStart, cleanupEnd, timezone)
 
 	if err != nil {
@@ -160,18 +668,54 @@ func maintainImages(ctx context.Context, kubeutil *kube.Kube, cleanupDays []stri
 
 	source := rand.NewSource(time.Now().UnixNano())
 	tick := delaytick.New(source, period)
-	for range tick {
-		now := time.Now()
-		if window.Contains(now) {
-			log.Info().Msgf("Start deleting images %s", now)
-			deleteImagesBelongingTo(ctx, kubeutil, registry, clusterType, activeClusterName,
-				deleteUntagged, retainLatestUntagged, performDelete, whitelisted)
-		} else {
-			log.Info().Msgf("%s is outside of window. Continue sleeping", now)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Stop maintaining images")
+			return
+		case now := <-tick:
+			if window.Contains(now) {
+				log.Info().Msgf("Start deleting images %s", now)
+				runCtx, cancel := withinWindow(ctx, window.Contains, windowCheckInterval)
+				deleteImagesBelongingTo(runCtx, kubeutil, usageSources, cleanups, repositoryCache, activeClusterName)
+				cancel()
+			} else {
+				log.Info().Msgf("%s is outside of window. Continue sleeping", now)
+			}
 		}
 	}
 }
 
+// withinWindow Returns a context which is cancelled when the cleanup window no longer contains the current time
+func withinWindow(ctx context.Context, contains func(time.Time) bool, checkInterval time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if !contains(now) {
+					cancel(errWindowClosed)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// isAborted Logs and returns true if the cleanup has been cancelled by shutdown or the end of the window
+func isAborted(ctx context.Context) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	log.Warn().Err(context.Cause(ctx)).Msg("Cleanup aborted")
+	return true
+}
+
 func initializeFlagSet() *pflag.FlagSet {
 	// Flag domain.
 	fs := pflag.NewFlagSet("default", pflag.ContinueOnError)
@@ -197,7 +741,7 @@ func parseFlagsFromArgs(fs *pflag.FlagSet) {
 	}
 }
 
-func deleteImagesBelongingTo(ctx context.Context, kubeutil *kube.Kube, registry, clusterType, activeClusterName string, deleteUntagged bool, retainLatestUntagged int, performDelete bool, whitelisted []string) {
+func deleteImagesBelongingTo(ctx context.Context, kubeutil *kube.Kube, usageSources []usage.Source, cleanups []registryCleanup, repositoryCache *cache.Cache, activeClusterName string) {
 	start := time.Now()
 
 	defer func() {
@@ -210,116 +754,748 @@ func deleteImagesBelongingTo(ctx context.Context, kubeutil *kube.Kube, registry,
 		return
 	}
 
-	imagesInCluster, err := listActiveImagesInCluster(ctx, kubeutil)
+	imagesInCluster, err := listActiveImagesInCluster(ctx, usageSources)
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to list images in cluster")
 		return
 	}
 
-	repositories, err := acr.ListRepositories(registry)
-	if err != nil {
-		log.Error().Err(err).Msg("Unable to get repositories")
-		return
+	if repositoryCache != nil {
+		if err := repositoryCache.Load(ctx); err != nil {
+			log.Warn().Err(err).Msg("Unable to load cache, all repositories are evaluated")
+		}
+		defer func() {
+			// Entries recorded before an abort are still saved
+			saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheSaveTimeout)
+			defer cancel()
+			if err := repositoryCache.Save(saveCtx); err != nil {
+				log.Error().Err(err).Msg("Unable to save cache")
+			}
+		}()
 	}
 
-	numRepositories := len(repositories)
-	processedRepositories := 0
-	for _, repository := range repositories {
-		if isWhitelisted(repository, whitelisted) {
-			log.Info().Str("repo", repository).Msg("Skip repository as it is whitelisted")
-			continue
+	cleanupRegistries(ctx, cleanups, imagesInCluster, start, repositoryCache)
+}
+
+// cleanupRegistries Cleans up the registries one at a time. The remaining registries are skipped if the run is aborted
+func cleanupRegistries(ctx context.Context, cleanups []registryCleanup, imagesInCluster []image.Data, start time.Time, repositoryCache *cache.Cache) {
+	for _, cleanup := range cleanups {
+		if isAborted(ctx) {
+			return
+		}
+		log.Info().Str("registry", cleanup.options.registryName).Msg("Start cleanup of registry")
+		options := cleanup.options
+		options.cache = repositoryCache
+		if err := cleanupRegistry(ctx, cleanup.registry, imagesInCluster, start, options); err != nil {
+			log.Error().Err(err).Msg("Run aborted, as a registry rejected the credentials")
+			return
 		}
+	}
+}
+
+// cleanupRegistry Deletes manifests no longer in use from all repositories which are not whitelisted,
+// processing up to options.concurrency repositories at the same time. Returns an error only if the run should be aborted
+func cleanupRegistry(ctx context.Context, reg registry.Registry, imagesInCluster []image.Data, start time.Time, options cleanupOptions) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	logger := log.With().Str("registry", options.registryName).Logger()
+	imagesInCluster = imagesInRegistry(imagesInCluster, options)
+
+	repositories := make(chan string)
+	var processedRepositories atomic.Int64
+	var wg sync.WaitGroup
+	for range max(options.concurrency, 1) {
+		wg.Go(func() {
+			for repository := range repositories {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				logger.Debug().Str("repo", repository).Msg("Process repository")
+				if err := cleanupRepository(ctx, reg, repository, imagesInCluster, start, options); skipsRepository(err) {
+					logger.Warn().Err(err).Str("repo", repository).Msg("Skip rest of repository, as access to it is forbidden")
+				} else if err != nil {
+					logger.Error().Err(err).Str("repo", repository).Msg("Cleanup aborted, as the registry rejected the credentials")
+					cancel(err)
+					continue
+				}
 
-		log.Debug().Str("repo", repository).Msg("Process repository")
-		manifests, err := acr.ListManifests(registry, repository)
+				if processed := processedRepositories.Add(1); (processed % 10) == 0 {
+					logger.Debug().Msgf("Processed %d repositories", processed)
+				}
+			}
+		})
+	}
+
+	for repository, err := range reg.ListRepositories(ctx) {
+		if isAborted(ctx) {
+			break
+		}
 		if err != nil {
-			log.Error().Str("repo", repository).Err(err).Msg("Unable to get manifests for repository")
-			addListManifestError(clusterType, repository)
+			logger.Error().Err(err).Str(reasonLabel, registry.Reason(err)).Msg("Unable to get repositories")
+			if abortsRun(err) {
+				cancel(err)
+			}
+			break
+		}
+
+		if isWhitelisted(repository, options.whitelisted) {
+			logger.Info().Str("repo", repository).Msg("Skip repository as it is whitelisted")
 			continue
 		}
-		numManifests := len(manifests)
 
-		for _, manifest := range manifests {
-			isNotTaggedForAnyClustertype := manifest.IsNotTaggedForAnyClustertype()
+		select {
+		case repositories <- repository:
+		case <-ctx.Done():
+		}
+	}
 
-			// If this manifest has a timestamp newer than start,
-			// the list of images might not be correct
-			// The grace period will prevent images from being deleted if they are created before, but close to, the start time.
-			if isManifestWithinGracePeriod(manifest, start, manifestGracePeriod) {
-				if isNotTaggedForAnyClustertype {
-					addUntaggedImageRetained(clusterType, repository)
-				} else {
-					addImageRetained(clusterType, repository)
-				}
+	close(repositories)
+	wg.Wait()
+	if err := context.Cause(ctx); abortsRun(err) {
+		return err
+	}
+	return nil
+}
 
-				continue
-			}
+// cleanupRepository Evaluates the manifests of a repository, listing it once
+//
+// Manifests retained on their own account, such as locked manifests and manifests in use, are decided page by page as they are listed.
+// The others are held back until the listing ends, as an image index or subject listed later may depend on them,
+// so only the manifests which may be deleted are kept in memory. Releases are held back as well when a release retention applies,
+// as the retained releases are known once all release versions have been listed.
+// Manifests referenced by an image index, and referrers such as signatures and SBOMs, are only deleted once every manifest
+// they depend on has been deleted. Untagged manifests are only deleted while the repository has more than retainLatestUntagged manifests left,
+// and manifests tagged for the cluster type are only deleted or untagged if retainLatestTagged more recent manifests tagged for the cluster type exist.
+// Locked repositories are skipped.
+// Returns an error only if the rest of the repository should be skipped, or the run should be aborted
+func cleanupRepository(ctx context.Context, reg registry.Registry, repository string, imagesInCluster []image.Data, start time.Time, options cleanupOptions) error {
+	ctx = log.With().Str("registry", options.registryName).Str("repo", repository).Logger().WithContext(ctx)
+	registryName := options.registryName
+	clusterType := options.clusterType
+	numManifests := 0
+	numDeleted := 0
+	numUntaggedDeleted := 0
+	pendingUntagged := make([]manifest.Data, 0)
+	// numTagged Number of manifests tagged for the cluster type seen so far
+	numTagged := 0
+	pendingTagged := make([]heldManifest, 0)
+	heldDependents := make(map[string]manifest.Data)
+	// settled Whether evaluating the repository again gives the same result, as long as it is unchanged
+	settled := true
+
+	attributes, err := reg.GetRepository(ctx, repository)
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		log.Ctx(ctx).Info().Err(err).Msg("Skip repository as it no longer exists")
+		return nil
+	case err != nil && (abortsRun(err) || skipsRepository(err)):
+		return err
+	case err != nil:
+		log.Ctx(ctx).Warn().Err(err).Msg("Unable to get repository attributes, locks on the repository are not checked")
+	case attributes.ChangeableAttributes.IsLocked():
+		log.Ctx(ctx).Info().Msg("Skip repository as it is locked")
+		addRepositoryLocked(registryName, clusterType, repository)
+		return nil
+	}
 
-			manifestExistInCluster := doesManifestExistInCluster(repository, manifest, imagesInCluster)
-			if isNotTaggedForAnyClustertype && !deleteUntagged {
-				addUntaggedImageRetained(clusterType, repository)
-				log.Debug().Str("repo", repository).Msgf("Manifest %s is untagged, %s, and is not mandated for deletion", manifest.Digest, strings.Join(manifest.Tags, ","))
-				continue
-			} else if isNotTaggedForAnyClustertype && deleteUntagged && !manifestExistInCluster {
-				if numManifests > retainLatestUntagged {
-					log.Debug().Str("repo", repository).Msgf("Manifest %s is untagged, %s, and is mandated for deletion", manifest.Digest, strings.Join(manifest.Tags, ","))
-					untagged := true
-					deleteManifest(registry, repository, clusterType, performDelete, untagged, manifest)
-					numManifests--
-				} else {
-					addUntaggedImageRetained(clusterType, repository)
-					log.Info().Str("repo", repository).Msgf("Manifest %s is untagged, %s, and is mandated for deletion, but will be retained", manifest.Digest, strings.Join(manifest.Tags, ","))
-				}
+	cacheKey := registryName + "/" + repository
+	cacheEntry := cache.Entry{
+		LastUpdateTime: attributes.LastUpdateTime,
+		ManifestCount:  attributes.ManifestCount,
+		Fingerprint:    repositoryFingerprint(repository, imagesInCluster, options),
+		Evaluated:      start,
+	}
+	useCache := options.cache != nil && !attributes.LastUpdateTime.IsZero()
+	if useCache {
+		if options.cache.Unchanged(cacheKey, cacheEntry, start) {
+			log.Ctx(ctx).Debug().Msg("Skip repository as it is unchanged since it was last evaluated")
+			addCacheHit(registryName, clusterType)
+			addRepositorySkipped(registryName, clusterType, repository)
+			return nil
+		}
+		addCacheMiss(registryName, clusterType)
+	}
+
+	listing := newDependentsListing()
+	// dependents Known once the listing has ended
+	var dependents *manifest.Dependents
+	retention, hasReleaseRetention := options.releaseRetention.Of(repository)
+	releaseVersions := make([]semver.Version, 0)
+	// keptReleases Known once the listing has ended, nil if no release retention applies to the repository
+	var keptReleases map[semver.Version]bool
+
+	dependentKind := func(manifest manifest.Data) manifestKind {
+		if dependents.IsReferrer(manifest.Digest) {
+			return referrerManifest
+		} else if manifest.IsNotTaggedForAnyClustertype(options.classifier) {
+			return untaggedManifest
+		}
+		return taggedManifest
+	}
 
+	// deleteReleased Deletes a manifest depending only on deleted manifests, unless it is to be retained on its own account
+	var deleteReleased func(manifest manifest.Data) error
+	// deleteAndRelease Deletes a manifest, followed by the held manifests depending only on deleted manifests
+	deleteAndRelease := func(manifest manifest.Data, kind manifestKind) error {
+		settled = false
+		deleted, err := deleteManifest(ctx, reg, repository, options, kind, manifest)
+		if err != nil || !deleted {
+			return err
+		}
+		numDeleted++
+
+		for _, digest := range dependents.ParentDeleted(manifest.Digest) {
+			child, ok := heldDependents[digest]
+			if !ok {
 				continue
 			}
+			delete(heldDependents, digest)
+			if err := deleteReleased(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	deleteReleased = func(manifest manifest.Data) error {
+		if isManifestWithinGracePeriod(manifest, start, manifestGracePeriod) {
+			settled = false
+		}
+		if isRetainedOnItsOwn(ctx, repository, manifest, imagesInCluster, keptReleases, start, options) {
+			return nil
+		}
+		log.Ctx(ctx).Debug().Msgf("Manifest %s depends only on deleted manifests, and is mandated for deletion", manifest.Digest)
+		return deleteAndRelease(manifest, dependentKind(manifest))
+	}
 
-			isTaggedForCurrentClustertype := manifest.IsTaggedForCurrentClustertype(clusterType)
-			if !isTaggedForCurrentClustertype {
-				addImageRetained(clusterType, repository)
-				log.Debug().Str("repo", repository).Msgf("Manifest %s is tagged for different cluster type, %s, and should not be deleted", manifest.Digest, strings.Join(manifest.Tags, ","))
-				continue
+	deletePendingUntagged := func() error {
+		for len(pendingUntagged) > 0 && numManifests-numUntaggedDeleted > options.retainLatestUntagged && ctx.Err() == nil {
+			manifest := pendingUntagged[0]
+			pendingUntagged = pendingUntagged[1:]
+			log.Ctx(ctx).Debug().Msgf("Manifest %s is untagged, %s, and is mandated for deletion", manifest.Digest, strings.Join(manifest.Tags, ","))
+			if err := deleteAndRelease(manifest, untaggedManifest); err != nil {
+				return err
+			}
+			numUntaggedDeleted++
+		}
+		return nil
+	}
+
+	applyVerdict := func(manifest manifest.Data, verdict verdict) error {
+		switch verdict {
+		case verdictDelete:
+			return deleteAndRelease(manifest, dependentKind(manifest))
+		case verdictUntag:
+			settled = false
+			return untagManifest(ctx, reg, repository, options, manifest)
+		}
+		return nil
+	}
+
+	applyPendingTagged := func() error {
+		for len(pendingTagged) > 0 && numTagged-pendingTagged[0].position >= options.retainLatestTagged && ctx.Err() == nil {
+			held := pendingTagged[0]
+			pendingTagged = pendingTagged[1:]
+			log.Ctx(ctx).Debug().Msgf("Manifest %s is not among the %d latest tagged for the cluster type", held.manifest.Digest, options.retainLatestTagged)
+			if err := applyVerdict(held.manifest, held.verdict); err != nil {
+				return err
 			}
+		}
+		return nil
+	}
+
+	// candidates Manifests which may be deleted, decided once the listing has ended
+	candidates := make([]heldManifest, 0)
+	for manifest, err := range reg.ListManifests(ctx, repository) {
+		if isAborted(ctx) {
+			return nil
+		}
+		if err == nil {
+			err = listing.add(ctx, reg, repository, manifest, options.discoverReferrers)
+		}
+		if err != nil {
+			return listManifestsFailed(ctx, registryName, clusterType, repository, err)
+		}
+		numManifests++
+		if manifest.IsTaggedForCurrentClustertype(options.classifier, clusterType) {
+			numTagged++
+		}
+		var versions []semver.Version
+		if hasReleaseRetention {
+			versions = manifest.ReleaseVersions(options.classifier)
+			releaseVersions = append(releaseVersions, versions...)
+		}
+
+		isNotTaggedForAnyClustertype := manifest.IsNotTaggedForAnyClustertype(options.classifier)
 
-			if !manifestExistInCluster {
-				untagged := false
-				deleteManifest(registry, repository, clusterType, performDelete, untagged, manifest)
+		switch {
+		// Locked manifests can not be deleted, and are always retained
+		case manifest.IsLocked():
+			log.Ctx(ctx).Debug().Msgf("Manifest %s is locked, %s, and should not be deleted", manifest.Digest, strings.Join(manifest.Tags, ","))
+			if isNotTaggedForAnyClustertype {
+				addUntaggedImageRetained(registryName, clusterType, repository, retainedLocked)
+			} else {
+				addImageRetained(registryName, clusterType, repository, retainedLocked)
+			}
+		// If this manifest has a timestamp newer than start,
+		// the list of images might not be correct
+		// The grace period will prevent images from being deleted if they are created before, but close to, the start time.
+		case isManifestWithinGracePeriod(manifest, start, manifestGracePeriod):
+			settled = false
+			if isNotTaggedForAnyClustertype {
+				addUntaggedImageRetained(registryName, clusterType, repository, retainedWithinGracePeriod)
 			} else {
-				addImageRetained(clusterType, repository)
-				log.Debug().Str("repo", repository).Msgf("Manifest %s exists in cluster for tags %s", manifest.Digest, strings.Join(manifest.Tags, ","))
+				addImageRetained(registryName, clusterType, repository, retainedWithinGracePeriod)
 			}
+		// Whether a release is retained depends on the releases listed later
+		case len(versions) > 0, !isRetainedOnItsOwn(ctx, repository, manifest, imagesInCluster, keptReleases, start, options):
+			candidates = append(candidates, heldManifest{manifest: manifest, position: numTagged})
+		}
+	}
+	dependents = listing.dependents()
+	if hasReleaseRetention {
+		keptReleases = semver.Retention{PatchesPerMinor: retention.PatchesPerMinor, MinorsPerMajor: retention.MinorsPerMajor}.Keep(releaseVersions)
+	}
+
+	for _, candidate := range candidates {
+		if isAborted(ctx) {
+			return nil
 		}
+		manifest := candidate.manifest
 
-		processedRepositories++
+		switch {
+		case dependents.IsReleased(manifest.Digest):
+			if err := deleteReleased(manifest); err != nil {
+				return err
+			}
+		case dependents.IsDependent(manifest.Digest):
+			heldDependents[manifest.Digest] = manifest
+		default:
+			verdict := evaluateManifest(ctx, repository, manifest, imagesInCluster, keptReleases, options, &pendingUntagged)
+			if verdict != verdictRetain && manifest.IsTaggedForCurrentClustertype(options.classifier, clusterType) && options.retainLatestTagged > 0 {
+				pendingTagged = append(pendingTagged, heldManifest{manifest: manifest, verdict: verdict, position: candidate.position})
+			} else if err := applyVerdict(manifest, verdict); err != nil {
+				return err
+			}
+		}
 
-		if (processedRepositories % 10) == 0 {
-			log.Debug().Msgf("Processed %d out of %d repositories", processedRepositories, numRepositories)
+		if err := applyPendingTagged(); err != nil {
+			return err
+		}
+		if err := deletePendingUntagged(); err != nil {
+			return err
 		}
 	}
+
+	for _, held := range pendingTagged {
+		addImageRetained(registryName, clusterType, repository, retainedLatestTagged)
+		log.Ctx(ctx).Info().Msgf("Manifest %s is tagged for the cluster type, %s, and is mandated for deletion, but will be retained as one of the %d latest", held.manifest.Digest, strings.Join(held.manifest.Tags, ","), options.retainLatestTagged)
+	}
+
+	for _, manifest := range pendingUntagged {
+		addUntaggedImageRetained(registryName, clusterType, repository, retainedLatestUntagged)
+		log.Ctx(ctx).Info().Msgf("Manifest %s is untagged, %s, and is mandated for deletion, but will be retained", manifest.Digest, strings.Join(manifest.Tags, ","))
+	}
+	for _, manifest := range heldDependents {
+		reason := retainedIndexChild
+		if dependents.IsReferrer(manifest.Digest) {
+			reason = retainedReferrer
+		}
+		if manifest.IsNotTaggedForAnyClustertype(options.classifier) {
+			addUntaggedImageRetained(registryName, clusterType, repository, reason)
+		} else {
+			addImageRetained(registryName, clusterType, repository, reason)
+		}
+		log.Ctx(ctx).Debug().Msgf("Manifest %s depends on a retained manifest, and should not be deleted", manifest.Digest)
+	}
+
+	if options.deleteEmptyRepositories && numDeleted == numManifests && !isAborted(ctx) {
+		return deleteEmptyRepository(ctx, reg, repository, start, options)
+	}
+	if useCache && settled && ctx.Err() == nil {
+		options.cache.Record(cacheKey, cacheEntry)
+	}
+	return nil
 }
 
-func deleteManifest(registry, repository, clusterType string, performDelete, untagged bool, manifest manifest.Data) {
-	if performDelete {
-		if err := acr.DeleteManifest(registry, repository, manifest); err != nil {
-			log.Error().Err(err).Msg("Error deleting manifest")
-			addImageDeleteError(clusterType, repository)
-			return
+// repositoryFingerprint Identifies the settings, and the tags and digests in use in the cluster, a repository is evaluated with
+func repositoryFingerprint(repository string, imagesInCluster []image.Data, options cleanupOptions) string {
+	tags := make([]string, 0)
+	for _, image := range imagesInCluster {
+		if strings.EqualFold(image.Repository, repository) {
+			tags = append(tags, image.Tag+"@"+image.Digest)
+		}
+	}
+	slices.Sort(tags)
+
+	hash := sha256.New()
+	retention, _ := options.releaseRetention.Of(repository)
+	fmt.Fprintf(hash, "%s|%s|%v|%t|%d|%d|%t|%t|%t|%t|", options.clusterType, options.classifier, retention, options.deleteUntagged, options.retainLatestUntagged, options.retainLatestTagged,
+		options.performDelete, options.untagShared, options.discoverReferrers, options.deleteEmptyRepositories)
+	for _, tag := range slices.Compact(tags) {
+		fmt.Fprintf(hash, "%s,", tag)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// deleteEmptyRepository Deletes a repository left without manifests, or only logs it if performDelete is false.
+// Repositories updated within emptyRepositoryMinAge, or where manifests have been pushed since the listing, are retained.
+// Returns an error only if the run should be aborted
+func deleteEmptyRepository(ctx context.Context, reg registry.Registry, repository string, start time.Time, options cleanupOptions) error {
+	registryName := options.registryName
+	clusterType := options.clusterType
+	attributes, err := reg.GetRepository(ctx, repository)
+	if err != nil {
+		if errors.Is(err, registry.ErrNotFound) || isAborted(ctx) {
+			return nil
+		}
+		log.Ctx(ctx).Error().Err(err).Str(reasonLabel, registry.Reason(err)).Msg("Unable to get repository attributes, empty repository is retained")
+		if abortsRun(err) {
+			return err
+		}
+		return nil
+	}
+
+	if attributes.LastUpdateTime.After(start.Add(-options.emptyRepositoryMinAge)) {
+		log.Ctx(ctx).Info().Msgf("Repository is empty, but was updated %s and will be retained", attributes.LastUpdateTime.Format(time.RFC3339))
+		return nil
+	}
+
+	if !options.performDelete {
+		log.Ctx(ctx).Info().Msg("Empty repository would have been deleted")
+		addRepositoryDeleted(registryName, clusterType, repository)
+		return nil
+	}
+
+	for _, err := range reg.ListManifests(ctx, repository) {
+		if err != nil {
+			return listManifestsFailed(ctx, registryName, clusterType, repository, err)
+		}
+		log.Ctx(ctx).Info().Msg("Manifests have been pushed to the empty repository, and it will be retained")
+		return nil
+	}
+
+	if err := waitForDelete(ctx, options.deleteLimiter); err != nil {
+		return nil
+	}
+	if err := reg.DeleteRepository(ctx, repository); err != nil {
+		switch {
+		case isAborted(ctx), errors.Is(err, registry.ErrNotFound):
+			return nil
+		case errors.Is(err, registry.ErrLocked):
+			log.Ctx(ctx).Info().Msg("Empty repository is locked and will be retained")
+			return nil
+		}
+
+		log.Ctx(ctx).Error().Err(err).Str(reasonLabel, registry.Reason(err)).Msg("Error deleting empty repository")
+		if abortsRun(err) {
+			return err
 		}
+		return nil
+	}
+
+	log.Ctx(ctx).Info().Msg("Deleted empty repository")
+	addRepositoryDeleted(registryName, clusterType, repository)
+	return nil
+}
+
+// dependentsListing Collects the manifests referenced by the image indexes of a repository, and the referrers
+// attached to its manifests, either found through the referrers API or tagged by the cosign tag convention, as the repository is listed
+type dependentsListing struct {
+	found           *manifest.Dependents
+	digests         map[string]bool
+	taggedReferrers map[string][]string
+}
+
+func newDependentsListing() *dependentsListing {
+	return &dependentsListing{
+		found:           manifest.NewDependents(),
+		digests:         make(map[string]bool),
+		taggedReferrers: make(map[string][]string),
+	}
+}
+
+// add Records a listed manifest, looking up the children of image indexes, and its referrers if discoverReferrers is set
+func (l *dependentsListing) add(ctx context.Context, reg registry.Registry, repository string, manifest manifest.Data, discoverReferrers bool) error {
+	l.digests[manifest.Digest] = true
 
-		log.Info().Str("repo", repository).Msgf("Deleted digest %s for repository %s for tags %s", manifest.Digest, repository, strings.Join(manifest.Tags, ","))
+	if subject, ok := manifest.Subject(); ok {
+		l.taggedReferrers[subject] = append(l.taggedReferrers[subject], manifest.Digest)
+	}
+
+	if manifest.IsIndex() {
+		children, err := reg.ListIndexChildren(ctx, repository, manifest.Digest)
+		if err != nil && !errors.Is(err, registry.ErrNotFound) {
+			return err
+		}
+		l.found.Add(manifest.Digest, children...)
+	}
+
+	if discoverReferrers {
+		// Registries without the referrers API respond with not found
+		referrers, err := reg.ListReferrers(ctx, repository, manifest.Digest)
+		if err != nil && !errors.Is(err, registry.ErrNotFound) {
+			return err
+		}
+		l.found.AddReferrers(manifest.Digest, referrers...)
+	}
+	return nil
+}
+
+// dependents Returns the dependents of the listed manifests
+func (l *dependentsListing) dependents() *manifest.Dependents {
+	// Referrers of deleted subjects are left to the rules for untagged manifests
+	for subject, referrers := range l.taggedReferrers {
+		if l.digests[subject] {
+			l.found.AddReferrers(subject, referrers...)
+		}
+	}
+	l.taggedReferrers = make(map[string][]string)
+	return l.found
+}
+
+// listManifestsFailed Logs a failed listing of a repository. Returns err only if the run should be aborted
+func listManifestsFailed(ctx context.Context, registryName, clusterType, repository string, err error) error {
+	if errors.Is(err, registry.ErrNotFound) {
+		log.Ctx(ctx).Info().Err(err).Msg("Skip repository as it no longer exists")
+		return nil
+	}
+
+	log.Ctx(ctx).Error().Err(err).Msg("Unable to get manifests for repository")
+	addListManifestError(registryName, clusterType, repository, registry.Reason(err))
+	if abortsRun(err) {
+		return err
+	}
+	return nil
+}
+
+// evaluateManifest Retains a manifest outside the grace period, or returns whether it is to be deleted or untagged.
+// keptReleases are the release versions retained by the release retention of the repository, nil if it has none.
+// Untagged manifests mandated for deletion are appended to pendingUntagged
+func evaluateManifest(ctx context.Context, repository string, manifest manifest.Data, imagesInCluster []image.Data, keptReleases map[semver.Version]bool, options cleanupOptions, pendingUntagged *[]manifest.Data) verdict {
+	registryName := options.registryName
+	clusterType := options.clusterType
+	isNotTaggedForAnyClustertype := manifest.IsNotTaggedForAnyClustertype(options.classifier)
+
+	addRetained := addImageRetained
+	if isNotTaggedForAnyClustertype {
+		addRetained = addUntaggedImageRetained
+	}
+	if manifest.HasProtectedTag(options.classifier) {
+		addRetained(registryName, clusterType, repository, retainedProtectedTag)
+		log.Ctx(ctx).Debug().Msgf("Manifest %s has a protected tag, %s, and should not be deleted", manifest.Digest, strings.Join(manifest.Tags, ","))
+		return verdictRetain
+	}
+
+	isRelease, isOutdatedRelease := releaseStatus(manifest, keptReleases, options)
+	if isRelease && !isOutdatedRelease {
+		addRetained(registryName, clusterType, repository, retainedReleaseTag)
+		log.Ctx(ctx).Debug().Msgf("Manifest %s has a release tag, %s, and should not be deleted", manifest.Digest, strings.Join(manifest.Tags, ","))
+		return verdictRetain
+	}
+
+	usedBy, matched := findManifestInCluster(repository, manifest, imagesInCluster)
+	manifestExistInCluster := matched != matchedNone
+	if manifestExistInCluster {
+		log.Ctx(ctx).Debug().Msgf("Manifest %s is used by %s, matched by %s", manifest.Digest, usedBy, matched)
+	}
+	if isOutdatedRelease && isNotTaggedForAnyClustertype && !manifestExistInCluster {
+		log.Ctx(ctx).Debug().Msgf("Manifest %s is an outdated release, %s, and is mandated for deletion", manifest.Digest, strings.Join(manifest.Tags, ","))
+		return verdictDelete
+	}
+	if isNotTaggedForAnyClustertype && manifest.HasCacheTag(options.classifier) && !manifestExistInCluster {
+		log.Ctx(ctx).Debug().Msgf("Manifest %s has a cache tag, %s, and is mandated for deletion", manifest.Digest, strings.Join(manifest.Tags, ","))
+		return verdictDelete
+	}
+	if isNotTaggedForAnyClustertype && !options.deleteUntagged {
+		addUntaggedImageRetained(registryName, clusterType, repository, retainedUntaggedNotMandated)
+		log.Ctx(ctx).Debug().Msgf("Manifest %s is untagged, %s, and is not mandated for deletion", manifest.Digest, strings.Join(manifest.Tags, ","))
+		return verdictRetain
+	} else if isNotTaggedForAnyClustertype && options.deleteUntagged && !manifestExistInCluster {
+		*pendingUntagged = append(*pendingUntagged, manifest)
+		return verdictRetain
+	}
+
+	isTaggedForCurrentClustertype := manifest.IsTaggedForCurrentClustertype(options.classifier, clusterType)
+	if !isTaggedForCurrentClustertype {
+		addImageRetained(registryName, clusterType, repository, retainedOtherClusterType)
+		log.Ctx(ctx).Debug().Msgf("Manifest %s is tagged for different cluster type, %s, and should not be deleted", manifest.Digest, strings.Join(manifest.Tags, ","))
+		return verdictRetain
+	}
+
+	if !manifestExistInCluster {
+		if options.untagShared && manifest.IsTaggedForOtherClustertype(options.classifier, clusterType) {
+			return verdictUntag
+		}
+		return verdictDelete
+	}
+
+	addImageRetained(registryName, clusterType, repository, retainedInUse)
+	log.Ctx(ctx).Debug().Msgf("Manifest %s exists in cluster for tags %s, matched by %s", manifest.Digest, strings.Join(manifest.Tags, ","), matched)
+	return verdictRetain
+}
+
+// releaseStatus Returns whether a manifest is a release, and whether all its release versions are outside the release retention.
+// keptReleases are the release versions retained by the release retention of the repository, nil if it has none
+func releaseStatus(manifest manifest.Data, keptReleases map[semver.Version]bool, options cleanupOptions) (isRelease, isOutdatedRelease bool) {
+	isRelease = manifest.HasReleaseTag(options.classifier)
+	if keptReleases != nil {
+		versions := manifest.ReleaseVersions(options.classifier)
+		isRelease = isRelease || len(versions) > 0
+		isOutdatedRelease = len(versions) > 0 && !slices.ContainsFunc(versions, func(version semver.Version) bool { return keptReleases[version] })
+	}
+	return isRelease, isOutdatedRelease
+}
+
+// isRetainedOnItsOwn Retains a manifest which is to be kept whatever manifests it depends on, or depend on it,
+// as it is within the grace period, has a protected or retained release tag, is tagged for other cluster types only, or is in use.
+// Returns true if it is retained
+func isRetainedOnItsOwn(ctx context.Context, repository string, manifest manifest.Data, imagesInCluster []image.Data, keptReleases map[semver.Version]bool, start time.Time, options cleanupOptions) bool {
+	addRetained := addImageRetained
+	if manifest.IsNotTaggedForAnyClustertype(options.classifier) {
+		addRetained = addUntaggedImageRetained
+	}
+	isRelease, isOutdatedRelease := releaseStatus(manifest, keptReleases, options)
+	usedBy, matched := findManifestInCluster(repository, manifest, imagesInCluster)
+
+	var reason retainReason
+	switch {
+	case isManifestWithinGracePeriod(manifest, start, manifestGracePeriod):
+		reason = retainedWithinGracePeriod
+	case manifest.HasProtectedTag(options.classifier):
+		reason = retainedProtectedTag
+	case isRelease && !isOutdatedRelease:
+		reason = retainedReleaseTag
+	case manifest.IsTaggedForOtherClustertype(options.classifier, options.clusterType) && !manifest.IsTaggedForCurrentClustertype(options.classifier, options.clusterType):
+		reason = retainedOtherClusterType
+	case matched != matchedNone:
+		reason = retainedInUse
+		log.Ctx(ctx).Debug().Msgf("Manifest %s is used by %s, matched by %s", manifest.Digest, usedBy, matched)
+	default:
+		return false
+	}
+
+	addRetained(options.registryName, options.clusterType, repository, reason)
+	log.Ctx(ctx).Debug().Msgf("Manifest %s, %s, should not be deleted, reason %s", manifest.Digest, strings.Join(manifest.Tags, ","), reason)
+	return true
+}
+
+// untagManifest Removes the tags of the current cluster type from a manifest also tagged for other cluster types,
+// or only logs it if performDelete is false. Returns an error only if the rest of the repository should be skipped, or the run should be aborted
+func untagManifest(ctx context.Context, reg registry.Registry, repository string, options cleanupOptions, manifest manifest.Data) error {
+	registryName := options.registryName
+	clusterType := options.clusterType
+	for _, tag := range manifest.ClusterTypeTags(options.classifier, clusterType) {
+		if options.performDelete {
+			if err := waitForDelete(ctx, options.deleteLimiter); err != nil {
+				return nil
+			}
+			if err := reg.Untag(ctx, repository, tag); err != nil {
+				if isAborted(ctx) {
+					return nil
+				}
+
+				switch {
+				case errors.Is(err, registry.ErrNotFound):
+					log.Ctx(ctx).Info().Msgf("Tag %s for digest %s was already removed", tag, manifest.Digest)
+					continue
+				case errors.Is(err, registry.ErrLocked):
+					log.Ctx(ctx).Info().Msgf("Digest %s for repository %s for tags %s is locked and will be retained", manifest.Digest, repository, strings.Join(manifest.Tags, ","))
+					addImageRetained(registryName, clusterType, repository, retainedLocked)
+					return nil
+				}
+
+				log.Ctx(ctx).Error().Err(err).Msg("Error removing tag")
+				addUntagError(registryName, clusterType, repository, registry.Reason(err))
+				if abortsRun(err) || skipsRepository(err) {
+					return err
+				}
+				continue
+			}
+
+			log.Ctx(ctx).Info().Msgf("Removed tag %s from digest %s for repository %s", tag, manifest.Digest, repository)
+		} else {
+			log.Ctx(ctx).Info().Msgf("Tag %s would have been removed from digest %s for repository %s", tag, manifest.Digest, repository)
+		}
+		addTagRemoved(registryName, clusterType, repository)
+	}
+
+	addImageRetained(registryName, clusterType, repository, retainedOtherClusterType)
+	return nil
+}
+
+// deleteManifest Deletes a manifest, or only logs it if performDelete is false. Returns true if the manifest is gone,
+// or would have been, and an error only if the rest of the repository should be skipped, or the run should be aborted
+func deleteManifest(ctx context.Context, reg registry.Registry, repository string, options cleanupOptions, kind manifestKind, manifest manifest.Data) (bool, error) {
+	registryName := options.registryName
+	clusterType := options.clusterType
+	if options.performDelete {
+		if err := waitForDelete(ctx, options.deleteLimiter); err != nil {
+			return false, nil
+		}
+		if err := reg.DeleteManifest(ctx, repository, manifest); err != nil {
+			if isAborted(ctx) {
+				return false, nil
+			}
+
+			switch {
+			case errors.Is(err, registry.ErrNotFound):
+				log.Ctx(ctx).Info().Msgf("Digest %s for repository %s was already deleted", manifest.Digest, repository)
+				return true, nil
+			case errors.Is(err, registry.ErrLocked):
+				log.Ctx(ctx).Info().Msgf("Digest %s for repository %s for tags %s is locked and will be retained", manifest.Digest, repository, strings.Join(manifest.Tags, ","))
+				if kind == taggedManifest {
+					addImageRetained(registryName, clusterType, repository, retainedLocked)
+				} else {
+					addUntaggedImageRetained(registryName, clusterType, repository, retainedLocked)
+				}
+				return false, nil
+			}
+
+			log.Ctx(ctx).Error().Err(err).Msg("Error deleting manifest")
+			addImageDeleteError(registryName, clusterType, repository, registry.Reason(err))
+			if abortsRun(err) || skipsRepository(err) {
+				return false, err
+			}
+			return false, nil
+		}
+
+		log.Ctx(ctx).Info().Msgf("Deleted digest %s for repository %s for tags %s", manifest.Digest, repository, strings.Join(manifest.Tags, ","))
 
 	} else {
-		log.Info().Str("repo", repository).Msgf("Digest %s for repository %s for tags %s would have been deleted", manifest.Digest, repository, strings.Join(manifest.Tags, ","))
+		log.Ctx(ctx).Info().Msgf("Digest %s for repository %s for tags %s would have been deleted", manifest.Digest, repository, strings.Join(manifest.Tags, ","))
 	}
 
 	// Will log a delete even if perform delete is false, so that
 	// we can test the consequences of this utility
-	if !untagged {
-		addImageDeleted(clusterType, repository)
-	} else {
-		addUntaggedImageDeleted(clusterType, repository)
+	switch kind {
+	case taggedManifest:
+		addImageDeleted(registryName, clusterType, repository)
+	case untaggedManifest:
+		addUntaggedImageDeleted(registryName, clusterType, repository)
+	case referrerManifest:
+		addReferrerDeleted(registryName, clusterType, repository)
 	}
 
+	return true, nil
+}
+
+// waitForDelete Blocks until limiter allows another delete request. A nil limiter allows all deletes
+func waitForDelete(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
+
+// abortsRun Indicates if err makes it pointless to continue the run, as every following request would fail the same way
+func abortsRun(err error) bool {
+	return errors.Is(err, registry.ErrUnauthorized)
+}
+
+// skipsRepository Indicates if err makes it pointless to continue with the repository, as access to it is forbidden
+func skipsRepository(err error) bool {
+	return errors.Is(err, registry.ErrForbidden)
 }
 
 func isWhitelisted(repository string, whitelisted []string) bool {
@@ -341,55 +1517,84 @@ func isActiveCluster(ctx context.Context, kubeutil *kube.Kube, activeClusterName
 	return strings.EqualFold(currentClusterName, activeClusterName)
 }
 
-// Checks if manifest exists in cluster
-func doesManifestExistInCluster(repository string, manifest manifest.Data, imagesInCluster []image.Data) bool {
-	manifestExistInCluster := false
+// imagesInRegistry Returns the images in the cluster referencing the registry by its login server or an alias.
+// The number of images referencing other registries is added to radix_acr_foreign_references_ignored
+func imagesInRegistry(imagesInCluster []image.Data, options cleanupOptions) []image.Data {
+	if len(options.loginServer) == 0 {
+		return imagesInCluster
+	}
 
+	images := make([]image.Data, 0, len(imagesInCluster))
 	for _, image := range imagesInCluster {
-		if strings.EqualFold(image.Repository, repository) {
-			if manifest.Contains(image.Tag) {
-				manifestExistInCluster = true
-				break
+		if strings.EqualFold(image.Registry, options.loginServer) || slices.ContainsFunc(options.registryAliases, func(alias string) bool {
+			return strings.EqualFold(image.Registry, strings.TrimSpace(alias))
+		}) {
+			images = append(images, image)
+		}
+	}
+
+	if ignored := len(imagesInCluster) - len(images); ignored > 0 {
+		log.Debug().Str("registry", options.registryName).Msgf("Ignore %d images in cluster referencing other registries", ignored)
+		addForeignReferencesIgnored(options.registryName, options.clusterType, ignored)
+	}
+	return images
+}
+
+// findManifestInCluster Returns an image in the cluster using the manifest, and how it was matched.
+// Images pinned to a digest only match the manifest with that digest, whatever their tag points to now,
+// while other images match the manifest having their tag. Matches by digest are preferred
+func findManifestInCluster(repository string, manifest manifest.Data, imagesInCluster []image.Data) (image.Data, matchStrategy) {
+	var usedBy image.Data
+	matched := matchedNone
+
+	for _, image := range imagesInCluster {
+		if !strings.EqualFold(image.Repository, repository) {
+			continue
+		}
+		if len(image.Digest) > 0 {
+			if image.Digest == manifest.Digest {
+				return image, matchedDigest
 			}
+		} else if matched == matchedNone && manifest.Contains(image.Tag) {
+			usedBy, matched = image, matchedTag
 		}
 	}
 
-	return manifestExistInCluster
+	return usedBy, matched
 }
 
-// Test if the manifest was created after a specified time and a grace period
+// Test if the manifest was created after a specified time and a grace period.
+// Manifests with unknown creation time are taken as within the grace period, as they may just have been pushed
 func isManifestWithinGracePeriod(manifest manifest.Data, time time.Time, gracePeriod time.Duration) bool {
+	if manifest.LastUpdateTime.IsZero() {
+		return true
+	}
 	createdWithGracePeriod := manifest.LastUpdateTime.Add(gracePeriod)
 	return createdWithGracePeriod.After(time)
 }
 
-// Lists distinct images in cluster based on all RadixDeployments
-func listActiveImagesInCluster(ctx context.Context, kubeutil *kube.Kube) ([]image.Data, error) {
+// Lists distinct images in cluster from all usage sources. A failing source fails the listing,
+// as images it would have reported could otherwise be deleted while in use
+func listActiveImagesInCluster(ctx context.Context, usageSources []usage.Source) ([]image.Data, error) {
 	imagesInCluster := make([]image.Data, 0)
+	seen := make(map[image.Data]bool)
 
-	rds, err := kubeutil.ListRadixDeployments(ctx, corev1.NamespaceAll)
-	if err != nil {
-		return imagesInCluster, err
-	}
-
-	for _, rd := range rds {
-		for _, component := range rd.Spec.Components {
-			componentImage := image.Parse(component.Image)
-			if componentImage == nil {
-				continue
-			}
-
-			imagesInCluster = append(imagesInCluster, *componentImage)
+	for _, source := range usageSources {
+		images, err := source.Images(ctx)
+		if err != nil {
+			return imagesInCluster, err
 		}
 
-		for _, job := range rd.Spec.Jobs {
-			componentImage := image.Parse(job.Image)
-			if componentImage == nil {
+		distinct := 0
+		for _, image := range images {
+			if seen[image] {
 				continue
 			}
-
-			imagesInCluster = append(imagesInCluster, *componentImage)
+			seen[image] = true
+			imagesInCluster = append(imagesInCluster, image)
+			distinct++
 		}
+		log.Debug().Str("source", source.Name()).Msgf("Found %d images in use, %d not found by earlier sources", len(images), distinct)
 	}
 
 	return imagesInCluster, nil
@@ -422,26 +1627,66 @@ func getKubernetesClient() (kubernetes.Interface, radixclient.Interface) {
 
 // Metrics
 
-func addUntaggedImageDeleted(clusterType, repository string) {
-	nrImagesDeleted.With(prometheus.Labels{clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "false"}).Inc()
+func addUntaggedImageDeleted(registryName, clusterType, repository string) {
+	nrImagesDeleted.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "false"}).Inc()
+}
+
+func addImageDeleted(registryName, clusterType, repository string) {
+	nrImagesDeleted.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "true"}).Inc()
+}
+
+func addReferrerDeleted(registryName, clusterType, repository string) {
+	nrReferrersDeleted.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository}).Inc()
+}
+
+func addTagRemoved(registryName, clusterType, repository string) {
+	nrTagsRemoved.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository}).Inc()
+}
+
+func addUntagError(registryName, clusterType, repository, reason string) {
+	nrUntagErrors.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository, reasonLabel: reason}).Inc()
+}
+
+func addUntaggedImageRetained(registryName, clusterType, repository string, reason retainReason) {
+	nrImagesRetained.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "false", reasonLabel: string(reason)}).Inc()
+}
+
+func addImageRetained(registryName, clusterType, repository string, reason retainReason) {
+	nrImagesRetained.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "true", reasonLabel: string(reason)}).Inc()
+}
+
+func addRepositoryDeleted(registryName, clusterType, repository string) {
+	nrRepositoriesDeleted.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository}).Inc()
+}
+
+func addRepositorySkipped(registryName, clusterType, repository string) {
+	nrRepositoriesSkipped.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository}).Inc()
+}
+
+func addCacheHit(registryName, clusterType string) {
+	nrCacheHits.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType}).Inc()
+}
+
+func addCacheMiss(registryName, clusterType string) {
+	nrCacheMisses.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType}).Inc()
 }
 
-func addImageDeleted(clusterType, repository string) {
-	nrImagesDeleted.With(prometheus.Labels{clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "true"}).Inc()
+func addForeignReferencesIgnored(registryName, clusterType string, count int) {
+	nrForeignReferencesIgnored.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType}).Add(float64(count))
 }
 
-func addUntaggedImageRetained(clusterType, repository string) {
-	nrImagesRetained.With(prometheus.Labels{clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "false"}).Inc()
+func addRepositoryLocked(registryName, clusterType, repository string) {
+	nrRepositoriesLocked.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository}).Inc()
 }
 
-func addImageRetained(clusterType, repository string) {
-	nrImagesRetained.With(prometheus.Labels{clusterTypeLabel: clusterType, repositoryLabel: repository, isTaggedLabel: "true"}).Inc()
+func addImageDeleteError(registryName, clusterType, repository, reason string) {
+	nrImagesDeleteErrors.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository, reasonLabel: reason}).Inc()
 }
 
-func addImageDeleteError(clusterType, repository string) {
-	nrImagesDeleteErrors.With(prometheus.Labels{clusterTypeLabel: clusterType, repositoryLabel: repository}).Inc()
+func addListManifestError(registryName, clusterType, repository, reason string) {
+	nrListManifestErrors.With(prometheus.Labels{registryLabel: registryName, clusterTypeLabel: clusterType, repositoryLabel: repository, reasonLabel: reason}).Inc()
 }
 
-func addListManifestError(clusterType, repository string) {
-	nrListManifestErrors.With(prometheus.Labels{clusterTypeLabel: clusterType, repositoryLabel: repository}).Inc()
+func addRequestRetry(registryName, method, reason string) {
+	nrRequestRetries.With(prometheus.Labels{registryLabel: registryName, methodLabel: method, reasonLabel: reason}).Inc()
 }
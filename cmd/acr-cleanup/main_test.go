@@ -1,11 +1,30 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/equinor/radix-acr-cleanup/pkg/aad"
+	"github.com/equinor/radix-acr-cleanup/pkg/cache"
+	"github.com/equinor/radix-acr-cleanup/pkg/image"
 	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/equinor/radix-acr-cleanup/pkg/policy"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry/fake"
+	"github.com/equinor/radix-acr-cleanup/pkg/usage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 )
 
 func Test_isManifestWithinGracePeriod(t *testing.T) {
@@ -18,4 +37,887 @@ func Test_isManifestWithinGracePeriod(t *testing.T) {
 
 	timeBefore, _ := time.Parse(time.RFC3339, "2010-01-01T14:00:00Z")
 	assert.True(t, isManifestWithinGracePeriod(manifest, timeBefore, 0))
+
+	manifest.LastUpdateTime = time.Time{}
+	assert.True(t, isManifestWithinGracePeriod(manifest, timeAfter, 0), "unknown creation time")
+}
+
+func Test_cleanupRegistry(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2020-01-01T12:00:00Z")
+	old := start.Add(-48 * time.Hour)
+	untagged := func(digest string, age time.Duration) manifest.Data {
+		return manifest.Data{Digest: digest, Tags: []string{digest}, LastUpdateTime: old.Add(age)}
+	}
+	tagged := func(digest, clusterType string) manifest.Data {
+		return manifest.Data{Digest: digest, Tags: []string{digest, clusterType + "-" + digest}, LastUpdateTime: old}
+	}
+	defaultOptions := cleanupOptions{clusterType: "development", performDelete: true}
+
+	tests := []struct {
+		name            string
+		manifests       []manifest.Data
+		imagesInCluster []image.Data
+		options         func(options *cleanupOptions)
+		expectDeleted   []string
+	}{
+		{
+			name:          "tagged for current cluster type and not in cluster is deleted",
+			manifests:     []manifest.Data{tagged("a", "development")},
+			expectDeleted: []string{"a"},
+		},
+		{
+			name:            "tagged for current cluster type and in cluster is retained",
+			manifests:       []manifest.Data{tagged("a", "development")},
+			imagesInCluster: []image.Data{{Repository: "app", Tag: "development-a"}},
+		},
+		{
+			name:      "tagged for other cluster type is retained",
+			manifests: []manifest.Data{tagged("a", "production")},
+		},
+		{
+			name: "manifest within grace period is retained",
+			manifests: []manifest.Data{
+				{Digest: "a", Tags: []string{"development-a"}, LastUpdateTime: start.Add(-time.Hour)},
+			},
+		},
+		{
+			name:      "untagged is retained when not mandated for deletion",
+			manifests: []manifest.Data{untagged("a", 0)},
+		},
+		{
+			name:          "untagged is deleted when mandated for deletion",
+			manifests:     []manifest.Data{untagged("a", 0), untagged("b", time.Minute)},
+			options:       func(options *cleanupOptions) { options.deleteUntagged = true },
+			expectDeleted: []string{"a", "b"},
+		},
+		{
+			name:            "untagged in cluster is retained",
+			manifests:       []manifest.Data{untagged("a", 0)},
+			imagesInCluster: []image.Data{{Repository: "app", Tag: "a"}},
+			options:         func(options *cleanupOptions) { options.deleteUntagged = true },
+		},
+		{
+			name:      "latest untagged are retained",
+			manifests: []manifest.Data{untagged("c", 2*time.Minute), untagged("a", 0), untagged("b", time.Minute)},
+			options: func(options *cleanupOptions) {
+				options.deleteUntagged = true
+				options.retainLatestUntagged = 2
+			},
+			expectDeleted: []string{"a"},
+		},
+		{
+			name: "latest untagged are retained",
+			manifests: []manifest.Data{
+				untagged("u1", 0), untagged("u2", time.Minute),
+				{Digest: "t", Tags: []string{"development-t"}, LastUpdateTime: old.Add(2 * time.Minute)},
+				untagged("u3", 3*time.Minute),
+			},
+			options: func(options *cleanupOptions) {
+				options.deleteUntagged = true
+				options.retainLatestUntagged = 2
+			},
+			expectDeleted: []string{"u1", "u2", "t"},
+		},
+		{
+			name: "releases outside the release retention are deleted",
+			manifests: []manifest.Data{
+				untagged("v1.0.0", 0), untagged("v1.0.1", time.Minute), untagged("v1.1.0", 2*time.Minute), untagged("v1.1.1", 3*time.Minute),
+				untagged("v0.1.0", 4*time.Minute),
+				{Digest: "p", Tags: []string{"v0.0.9", "production-p"}, LastUpdateTime: old.Add(5 * time.Minute)},
+				{Digest: "d", Tags: []string{"v0.0.8", "development-d"}, LastUpdateTime: old.Add(6 * time.Minute)},
+			},
+			imagesInCluster: []image.Data{{Repository: "app", Tag: "v1.0.1"}},
+			options: func(options *cleanupOptions) {
+				options.releaseRetention = policy.ReleaseRetentions{
+					{Repositories: "base-*", PatchesPerMinor: 5, MinorsPerMajor: 5},
+					{Repositories: "*", PatchesPerMinor: 1, MinorsPerMajor: 1},
+				}
+			},
+			expectDeleted: []string{"v1.0.0", "v1.1.0", "d"},
+		},
+		{
+			name:      "releases are untagged in repositories without release retention",
+			manifests: []manifest.Data{untagged("v1.0.0", 0), untagged("v1.0.1", time.Minute)},
+			options: func(options *cleanupOptions) {
+				options.releaseRetention = policy.ReleaseRetentions{{Repositories: "base-*", PatchesPerMinor: 1, MinorsPerMajor: 1}}
+			},
+		},
+		{
+			name: "latest tagged for current cluster type are retained whether in use or not",
+			manifests: []manifest.Data{
+				{Digest: "a", Tags: []string{"development-a"}, LastUpdateTime: old},
+				{Digest: "b", Tags: []string{"development-b"}, LastUpdateTime: old.Add(time.Minute)},
+				{Digest: "p", Tags: []string{"production-p"}, LastUpdateTime: old.Add(2 * time.Minute)},
+				{Digest: "c", Tags: []string{"development-c"}, LastUpdateTime: old.Add(3 * time.Minute)},
+				{Digest: "d", Tags: []string{"development-d"}, LastUpdateTime: old.Add(4 * time.Minute)},
+				{Digest: "u", Tags: []string{"u"}, LastUpdateTime: old.Add(5 * time.Minute)},
+			},
+			imagesInCluster: []image.Data{{Repository: "app", Tag: "development-d"}},
+			options: func(options *cleanupOptions) {
+				options.deleteUntagged = true
+				options.retainLatestUntagged = 0
+				options.retainLatestTagged = 2
+			},
+			expectDeleted: []string{"a", "b", "u"},
+		},
+		{
+			name:      "whitelisted repository is skipped",
+			manifests: []manifest.Data{tagged("a", "development")},
+			options:   func(options *cleanupOptions) { options.whitelisted = []string{"APP"} },
+		},
+		{
+			name:      "nothing is deleted when perform delete is false",
+			manifests: []manifest.Data{tagged("a", "development")},
+			options:   func(options *cleanupOptions) { options.performDelete = false },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := defaultOptions
+			if test.options != nil {
+				test.options(&options)
+			}
+			reg := fake.New().AddManifests("app", test.manifests...)
+
+			cleanupRegistry(context.Background(), reg, test.imagesInCluster, start, options)
+
+			var deleted []string
+			for _, call := range reg.CallsTo(fake.DeleteManifest) {
+				deleted = append(deleted, call.Digest)
+			}
+			assert.Equal(t, test.expectDeleted, deleted)
+		})
+	}
+}
+
+func Test_cleanupRegistry_ContinuesAfterListManifestsError(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().
+		AddManifests("broken", manifest.Data{Digest: "a", Tags: []string{"development-a"}, LastUpdateTime: old}).
+		AddManifests("ok", manifest.Data{Digest: "b", Tags: []string{"development-b"}, LastUpdateTime: old}).
+		SetError(fake.ListManifests, "broken", errors.New("boom"))
+
+	cleanupRegistry(context.Background(), reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true})
+
+	assert.Equal(t, []fake.Call{{Method: fake.DeleteManifest, Repository: "ok", Digest: "b"}}, reg.CallsTo(fake.DeleteManifest))
+	assert.Len(t, reg.Manifests("broken"), 1)
+}
+
+func Test_cleanupRegistry_AbortsOnUnauthorized(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().
+		AddManifests("a", manifest.Data{Digest: "a1", Tags: []string{"development-a"}, LastUpdateTime: old}).
+		AddManifests("b", manifest.Data{Digest: "b1", Tags: []string{"development-b"}, LastUpdateTime: old}).
+		SetError(fake.DeleteManifest, "", &registry.Error{Kind: registry.ErrUnauthorized})
+
+	cleanupRegistry(context.Background(), reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true})
+
+	assert.Equal(t, []fake.Call{{Method: fake.DeleteManifest, Repository: "a", Digest: "a1"}}, reg.CallsTo(fake.DeleteManifest))
+	assert.Equal(t, []string{"a"}, listedRepositories(reg))
+}
+
+func Test_cleanupRegistries_AbortsOnUnauthorized(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	rejecting := fake.New().
+		AddManifests("a", manifest.Data{Digest: "a1", Tags: []string{"development-a"}, LastUpdateTime: old}).
+		SetError(fake.DeleteManifest, "", &registry.Error{Kind: registry.ErrUnauthorized})
+	next := fake.New().
+		AddManifests("b", manifest.Data{Digest: "b1", Tags: []string{"development-b"}, LastUpdateTime: old})
+	options := cleanupOptions{clusterType: "development", performDelete: true}
+
+	cleanupRegistries(context.Background(), []registryCleanup{{registry: rejecting, options: options}, {registry: next, options: options}}, nil, start, nil)
+
+	assert.Len(t, rejecting.CallsTo(fake.DeleteManifest), 1)
+	assert.Empty(t, next.Calls())
+}
+
+func Test_cleanupRegistry_SkipsRepositoryOnForbidden(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().
+		AddManifests("a", manifest.Data{Digest: "a1", Tags: []string{"development-a"}, LastUpdateTime: old}, manifest.Data{Digest: "a2", Tags: []string{"development-b"}, LastUpdateTime: old}).
+		AddManifests("b", manifest.Data{Digest: "b1", Tags: []string{"development-b"}, LastUpdateTime: old}).
+		SetError(fake.DeleteManifest, "a", &registry.Error{Kind: registry.ErrForbidden})
+
+	cleanupRegistry(context.Background(), reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true})
+
+	assert.Equal(t, []fake.Call{{Method: fake.DeleteManifest, Repository: "a", Digest: "a1"}, {Method: fake.DeleteManifest, Repository: "b", Digest: "b1"}}, reg.CallsTo(fake.DeleteManifest))
+	assert.Len(t, reg.Manifests("a"), 2)
+	assert.Empty(t, reg.Manifests("b"))
+}
+
+func Test_cleanupRegistry_ContinuesAfterLockedOrMissingManifest(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	for _, kind := range []error{registry.ErrLocked, registry.ErrNotFound} {
+		reg := fake.New().
+			AddManifests("a", manifest.Data{Digest: "a1", Tags: []string{"development-a"}, LastUpdateTime: old}).
+			AddManifests("b", manifest.Data{Digest: "b1", Tags: []string{"development-b"}, LastUpdateTime: old}).
+			SetError(fake.DeleteManifest, "a", &registry.Error{Kind: kind})
+
+		cleanupRegistry(context.Background(), reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true})
+
+		assert.Len(t, reg.CallsTo(fake.DeleteManifest), 2, kind.Error())
+		assert.Empty(t, reg.Manifests("b"), kind.Error())
+	}
+}
+
+func Test_cleanupRegistry_ProcessesRepositoriesConcurrently(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New()
+	var expected []string
+	for i := range 50 {
+		repository := fmt.Sprintf("app-%02d", i)
+		reg.AddManifests(repository,
+			manifest.Data{Digest: repository + "-a", Tags: []string{"development-a"}, LastUpdateTime: old},
+			manifest.Data{Digest: repository + "-b", Tags: []string{"production-b"}, LastUpdateTime: old})
+		expected = append(expected, repository+"-a")
+	}
+
+	cleanupRegistry(context.Background(), reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true, concurrency: 8})
+
+	var deleted []string
+	for _, call := range reg.CallsTo(fake.DeleteManifest) {
+		deleted = append(deleted, call.Digest)
+	}
+	assert.ElementsMatch(t, expected, deleted)
+}
+
+func Test_cleanupRegistry_LimitsDeleteRate(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New()
+	for i := range 4 {
+		repository := fmt.Sprintf("app-%d", i)
+		reg.AddManifests(repository, manifest.Data{Digest: repository, Tags: []string{"development-a"}, LastUpdateTime: old})
+	}
+	limiter := rate.NewLimiter(rate.Every(20*time.Millisecond), 1)
+
+	before := time.Now()
+	cleanupRegistry(context.Background(), reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true, concurrency: 4, deleteLimiter: limiter})
+
+	assert.Len(t, reg.CallsTo(fake.DeleteManifest), 4)
+	assert.GreaterOrEqual(t, time.Since(before), 60*time.Millisecond)
+}
+
+func Test_cleanupRegistry_UntagsShared(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	shared := manifest.Data{Digest: "shared", Tags: []string{"1", "development-1", "production-1"}, LastUpdateTime: old}
+	own := manifest.Data{Digest: "own", Tags: []string{"2", "development-2"}, LastUpdateTime: old}
+
+	tests := []struct {
+		name          string
+		options       cleanupOptions
+		expectUntag   []fake.Call
+		expectDeleted []fake.Call
+	}{
+		{
+			name:    "shared manifest is deleted without untag-shared",
+			options: cleanupOptions{clusterType: "development", performDelete: true},
+			expectDeleted: []fake.Call{
+				{Method: fake.DeleteManifest, Repository: "app", Digest: "shared"},
+				{Method: fake.DeleteManifest, Repository: "app", Digest: "own"},
+			},
+		},
+		{
+			name:          "cluster type tags are removed from shared manifest",
+			options:       cleanupOptions{clusterType: "development", performDelete: true, untagShared: true},
+			expectUntag:   []fake.Call{{Method: fake.Untag, Repository: "app", Tag: "development-1"}},
+			expectDeleted: []fake.Call{{Method: fake.DeleteManifest, Repository: "app", Digest: "own"}},
+		},
+		{
+			name:    "nothing is removed without perform-delete",
+			options: cleanupOptions{clusterType: "development", untagShared: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reg := fake.New().AddManifests("app", shared, own)
+
+			cleanupRegistry(context.Background(), reg, nil, start, test.options)
+
+			assert.Equal(t, test.expectUntag, reg.CallsTo(fake.Untag))
+			assert.Equal(t, test.expectDeleted, reg.CallsTo(fake.DeleteManifest))
+		})
+	}
+}
+
+func Test_cleanupRegistry_DeletesEmptyRepositories(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	options := cleanupOptions{
+		clusterType:             "development",
+		performDelete:           true,
+		whitelisted:             []string{"whitelisted"},
+		deleteEmptyRepositories: true,
+		emptyRepositoryMinAge:   24 * time.Hour,
+	}
+
+	tests := []struct {
+		name          string
+		options       func(options *cleanupOptions)
+		expectDeleted []fake.Call
+	}{
+		{
+			name: "empty and emptied repositories are deleted",
+			expectDeleted: []fake.Call{
+				{Method: fake.DeleteRepository, Repository: "emptied"},
+				{Method: fake.DeleteRepository, Repository: "empty"},
+			},
+		},
+		{
+			name:    "nothing is deleted without delete-empty-repositories",
+			options: func(options *cleanupOptions) { options.deleteEmptyRepositories = false },
+		},
+		{
+			name:    "nothing is deleted without perform-delete",
+			options: func(options *cleanupOptions) { options.performDelete = false },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reg := fake.New().
+				AddManifests("empty").
+				AddManifests("emptied", manifest.Data{Digest: "a", Tags: []string{"development-a"}, LastUpdateTime: old}).
+				AddManifests("retained", manifest.Data{Digest: "b", Tags: []string{"production-b"}, LastUpdateTime: old}).
+				AddManifests("recent").
+				SetRepositoryUpdated("recent", start.Add(-time.Hour)).
+				AddManifests("whitelisted")
+			options := options
+			if test.options != nil {
+				test.options(&options)
+			}
+
+			cleanupRegistry(context.Background(), reg, nil, start, options)
+
+			assert.Equal(t, test.expectDeleted, reg.CallsTo(fake.DeleteRepository))
+		})
+	}
+}
+
+func Test_cleanupRegistry_RetainsLocked(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	deleteEnabled := false
+	locked := manifest.ChangeableAttributes{DeleteEnabled: &deleteEnabled}
+	reg := fake.New().
+		AddManifests("app",
+			manifest.Data{Digest: "locked", Tags: []string{"development-a"}, LastUpdateTime: old, ChangeableAttributes: locked},
+			manifest.Data{Digest: "open", Tags: []string{"development-b"}, LastUpdateTime: old}).
+		AddManifests("locked-repo", manifest.Data{Digest: "c", Tags: []string{"development-c"}, LastUpdateTime: old}).
+		SetRepositoryAttributes("locked-repo", locked)
+
+	cleanupRegistry(context.Background(), reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true})
+
+	assert.Equal(t, []fake.Call{{Method: fake.DeleteManifest, Repository: "app", Digest: "open"}}, reg.CallsTo(fake.DeleteManifest))
+	assert.Equal(t, []string{"app"}, listedRepositories(reg))
+}
+
+func Test_cleanupRegistry_DecidesIndexChildrenThroughTheirIndexes(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	platform := func(digest string) manifest.Data {
+		return manifest.Data{Digest: digest, MediaType: manifest.MediaTypeOCIManifest, LastUpdateTime: old}
+	}
+	index := func(digest, tag string) manifest.Data {
+		return manifest.Data{Digest: digest, Tags: []string{tag}, LastUpdateTime: old.Add(time.Minute)}
+	}
+	options := cleanupOptions{clusterType: "development", deleteUntagged: true, performDelete: true}
+
+	tests := []struct {
+		name            string
+		imagesInCluster []image.Data
+		expectDeleted   []string
+	}{
+		{
+			name:            "children of an index in use are retained",
+			imagesInCluster: []image.Data{{Repository: "app", Tag: "development-used"}},
+			expectDeleted:   []string{"unused", "unused-amd64"},
+		},
+		{
+			name:          "children are deleted after all indexes referencing them",
+			expectDeleted: []string{"unused", "unused-amd64", "used", "used-amd64", "shared-arm64"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reg := fake.New().
+				AddManifests("app", platform("unused-amd64"), platform("shared-arm64"), platform("used-amd64")).
+				AddIndex("app", index("unused", "development-unused"), "unused-amd64", "shared-arm64").
+				AddIndex("app", index("used", "development-used"), "used-amd64", "shared-arm64")
+
+			cleanupRegistry(context.Background(), reg, test.imagesInCluster, start, options)
+
+			var deleted []string
+			for _, call := range reg.CallsTo(fake.DeleteManifest) {
+				deleted = append(deleted, call.Digest)
+			}
+			assert.Equal(t, test.expectDeleted, deleted)
+		})
+	}
+}
+
+func Test_cleanupRegistry_RetainsReleasedChildInUse(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().
+		AddManifests("app", manifest.Data{Digest: "sha256:amd64", MediaType: manifest.MediaTypeOCIManifest, LastUpdateTime: old}).
+		AddIndex("app", manifest.Data{Digest: "sha256:index", Tags: []string{"development-a"}, LastUpdateTime: old}, "sha256:amd64")
+	imagesInCluster := []image.Data{{Repository: "app", Tag: "development-b", Digest: "sha256:amd64"}}
+
+	cleanupRegistry(context.Background(), reg, imagesInCluster, start, cleanupOptions{clusterType: "development", deleteUntagged: true, performDelete: true})
+
+	assert.Equal(t, []fake.Call{{Method: fake.DeleteManifest, Repository: "app", Digest: "sha256:index"}}, reg.CallsTo(fake.DeleteManifest))
+}
+
+func Test_isRetainedOnItsOwn(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	options := cleanupOptions{clusterType: "development", classifier: newClassifier(nil, manifest.Rule{Glob: "keep-*", Category: manifest.CategoryProtected})}
+
+	tests := []struct {
+		name            string
+		manifest        manifest.Data
+		imagesInCluster []image.Data
+		expectRetain    bool
+	}{
+		{name: "untagged manifest is not retained", manifest: manifest.Data{Digest: "sha256:a", LastUpdateTime: old}},
+		{name: "manifest tagged for the cluster type is not retained", manifest: manifest.Data{Digest: "sha256:a", Tags: []string{"development-a"}, LastUpdateTime: old}},
+		{name: "manifest within grace period is retained", manifest: manifest.Data{Digest: "sha256:a", LastUpdateTime: start}, expectRetain: true},
+		{name: "manifest in use by digest is retained", manifest: manifest.Data{Digest: "sha256:a", LastUpdateTime: old}, imagesInCluster: []image.Data{{Repository: "app", Tag: "development-b", Digest: "sha256:a"}}, expectRetain: true},
+		{name: "manifest tagged for other cluster type is retained", manifest: manifest.Data{Digest: "sha256:a", Tags: []string{"production-a"}, LastUpdateTime: old}, expectRetain: true},
+		{name: "manifest with protected tag is retained", manifest: manifest.Data{Digest: "sha256:a", Tags: []string{"keep-a"}, LastUpdateTime: old}, expectRetain: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectRetain, isRetainedOnItsOwn(context.Background(), "app", test.manifest, test.imagesInCluster, nil, start, options))
+		})
+	}
+}
+
+func Test_cleanupRegistry_DecidesReferrersThroughTheirSubject(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	subjectHex := "7f8343822a17acc74c88c96badc2a1a981ad9fc749b4c5194816c0ef01fc9457"
+	subject := manifest.Data{Digest: "sha256:" + subjectHex, Tags: []string{"development-a"}, LastUpdateTime: old}
+	signature := manifest.Data{Digest: "signature", Tags: []string{"sha256-" + subjectHex + ".sig"}, LastUpdateTime: old.Add(time.Minute)}
+	sbom := manifest.Data{Digest: "sbom", LastUpdateTime: old.Add(time.Minute)}
+	orphan := manifest.Data{Digest: "orphan", Tags: []string{"sha256-0000000000000000000000000000000000000000000000000000000000000000.sig"}, LastUpdateTime: old}
+	options := cleanupOptions{clusterType: "development", deleteUntagged: true, performDelete: true, discoverReferrers: true}
+
+	tests := []struct {
+		name            string
+		imagesInCluster []image.Data
+		expectDeleted   []string
+	}{
+		{
+			name:            "referrers of a subject in use are retained",
+			imagesInCluster: []image.Data{{Repository: "app", Tag: "development-a"}},
+			expectDeleted:   []string{"orphan"},
+		},
+		{
+			name:          "referrers are deleted together with their subject",
+			expectDeleted: []string{"orphan", subject.Digest, "signature", "sbom"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reg := fake.New().
+				AddManifests("app", orphan, subject, signature).
+				AddReferrers("app", subject.Digest, sbom)
+
+			cleanupRegistry(context.Background(), reg, test.imagesInCluster, start, options)
+
+			var deleted []string
+			for _, call := range reg.CallsTo(fake.DeleteManifest) {
+				deleted = append(deleted, call.Digest)
+			}
+			assert.Equal(t, test.expectDeleted, deleted)
+		})
+	}
+}
+
+func Test_dependentsListing(t *testing.T) {
+	subjectHex := "7f8343822a17acc74c88c96badc2a1a981ad9fc749b4c5194816c0ef01fc9457"
+	subject := manifest.Data{Digest: "sha256:" + subjectHex, Tags: []string{"development-a"}}
+	signature := manifest.Data{Digest: "signature", Tags: []string{"sha256-" + subjectHex + ".sig"}}
+	orphan := manifest.Data{Digest: "orphan", Tags: []string{"sha256-0000000000000000000000000000000000000000000000000000000000000000.sig"}}
+	newRegistry := func() *fake.Registry {
+		return fake.New().
+			AddManifests("app", manifest.Data{Digest: "amd64", MediaType: manifest.MediaTypeOCIManifest}, subject, signature, orphan).
+			AddIndex("app", manifest.Data{Digest: "index", Tags: []string{"development-b"}}, "amd64").
+			AddReferrers("app", subject.Digest, manifest.Data{Digest: "sbom"})
+	}
+
+	list := func(reg *fake.Registry, discoverReferrers bool) *manifest.Dependents {
+		listing := newDependentsListing()
+		for listed, err := range reg.ListManifests(context.Background(), "app") {
+			require.NoError(t, err)
+			require.NoError(t, listing.add(context.Background(), reg, "app", listed, discoverReferrers))
+		}
+		return listing.dependents()
+	}
+
+	reg := newRegistry()
+	dependents := list(reg, false)
+	assert.True(t, dependents.IsDependent("amd64"))
+	assert.True(t, dependents.IsReferrer("signature"))
+	assert.False(t, dependents.IsDependent("orphan"), "referrers of missing subjects are not dependents")
+	assert.False(t, dependents.IsDependent("sbom"), "referrers are only looked up when discovering referrers")
+	assert.Empty(t, reg.CallsTo(fake.ListReferrers))
+
+	reg = newRegistry()
+	dependents = list(reg, true)
+	assert.True(t, dependents.IsReferrer("sbom"))
+	assert.NotEmpty(t, reg.CallsTo(fake.ListReferrers))
+}
+
+func Test_cleanupRegistry_ListsManifestsOnce(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().
+		AddManifests("app",
+			manifest.Data{Digest: "v1.0.0", Tags: []string{"v1.0.0"}, LastUpdateTime: old},
+			manifest.Data{Digest: "v1.0.1", Tags: []string{"v1.0.1"}, LastUpdateTime: old.Add(time.Minute)},
+			manifest.Data{Digest: "amd64", MediaType: manifest.MediaTypeOCIManifest, LastUpdateTime: old}).
+		AddIndex("app", manifest.Data{Digest: "index", Tags: []string{"development-a"}, LastUpdateTime: old}, "amd64")
+	options := cleanupOptions{
+		clusterType:       "development",
+		deleteUntagged:    true,
+		performDelete:     true,
+		discoverReferrers: true,
+		releaseRetention:  policy.ReleaseRetentions{{Repositories: "*", PatchesPerMinor: 1, MinorsPerMajor: 1}},
+	}
+
+	cleanupRegistry(context.Background(), reg, nil, start, options)
+
+	assert.Len(t, reg.CallsTo(fake.ListManifests), 1)
+	var deleted []string
+	for _, call := range reg.CallsTo(fake.DeleteManifest) {
+		deleted = append(deleted, call.Digest)
+	}
+	assert.ElementsMatch(t, []string{"v1.0.0", "index", "amd64"}, deleted)
+}
+
+func Test_cleanupRegistry_StopsWhenCancelled(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().
+		AddManifests("app", manifest.Data{Digest: "a", Tags: []string{"development-a"}, LastUpdateTime: old})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cleanupRegistry(ctx, reg, nil, start, cleanupOptions{clusterType: "development", performDelete: true})
+
+	assert.Empty(t, reg.CallsTo(fake.DeleteManifest))
+}
+
+func Test_withinWindow(t *testing.T) {
+	var open atomic.Bool
+	open.Store(true)
+	ctx, cancel := withinWindow(context.Background(), func(time.Time) bool { return open.Load() }, time.Millisecond)
+	defer cancel()
+
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, ctx.Err())
+
+	open.Store(false)
+	select {
+	case <-ctx.Done():
+		assert.ErrorIs(t, context.Cause(ctx), errWindowClosed)
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled when window closed")
+	}
+}
+
+func Test_cleanupRegistry_SkipsUnchangedRepositories(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().
+		AddManifests("app", manifest.Data{Digest: "a", Tags: []string{"development-a"}, LastUpdateTime: old}).
+		SetRepositoryUpdated("app", old).
+		AddManifests("other", manifest.Data{Digest: "b", Tags: []string{"production-b"}, LastUpdateTime: old}).
+		SetRepositoryUpdated("other", old).
+		AddManifests("unknown-update", manifest.Data{Digest: "c", Tags: []string{"production-c"}, LastUpdateTime: old})
+	repositoryCache := cache.New(cache.FileStore{Path: filepath.Join(t.TempDir(), "cache.json")}, 24*time.Hour)
+	options := cleanupOptions{registryName: "radixdev", clusterType: "development", performDelete: true, cache: repositoryCache}
+
+	run := func(imagesInCluster []image.Data, start time.Time) []string {
+		listed := len(reg.CallsTo(fake.ListManifests))
+		require.NoError(t, repositoryCache.Load(context.Background()))
+		cleanupRegistry(context.Background(), reg, imagesInCluster, start, options)
+		require.NoError(t, repositoryCache.Save(context.Background()))
+		return repositoriesOf(reg.CallsTo(fake.ListManifests)[listed:])
+	}
+	inUse := []image.Data{{Repository: "app", Tag: "development-a"}}
+
+	assert.Equal(t, []string{"app", "other", "unknown-update"}, run(inUse, start))
+	assert.Equal(t, []string{"unknown-update"}, run(inUse, start.Add(time.Hour)), "unchanged repositories should be skipped")
+
+	reg.SetRepositoryUpdated("other", start)
+	assert.Equal(t, []string{"other", "unknown-update"}, run(inUse, start.Add(2*time.Hour)), "updated repository should be evaluated")
+
+	assert.Equal(t, []string{"app", "unknown-update"}, run(nil, start.Add(3*time.Hour)), "repository with changed usage should be evaluated")
+	assert.Equal(t, []fake.Call{{Method: fake.DeleteManifest, Repository: "app", Digest: "a"}}, reg.CallsTo(fake.DeleteManifest))
+	assert.Equal(t, []string{"app", "unknown-update"}, run(nil, start.Add(4*time.Hour)), "repository where manifests were deleted should be evaluated again")
+
+	assert.Equal(t, []string{"other", "unknown-update"}, run(nil, start.Add(26*time.Hour)), "repository evaluated too long ago should be evaluated")
+}
+
+func listedRepositories(reg *fake.Registry) []string {
+	return repositoriesOf(reg.CallsTo(fake.ListManifests))
+}
+
+func repositoriesOf(calls []fake.Call) []string {
+	var repositories []string
+	for _, call := range calls {
+		if !slices.Contains(repositories, call.Repository) {
+			repositories = append(repositories, call.Repository)
+		}
+	}
+	return repositories
+}
+
+func Test_registriesToClean(t *testing.T) {
+	cleanupPolicy := &policy.Policy{Registries: []policy.Registry{{Name: "radixcache"}, {Name: "radixdev"}}}
+
+	assert.Equal(t, []string{"radixdev", "radixcache"}, registriesToClean([]string{"radixdev", " radixdev", ""}, cleanupPolicy))
+	assert.Equal(t, []string{"radixdev"}, registriesToClean([]string{"radixdev"}, nil))
+	assert.Equal(t, []string{"radixcache", "radixdev"}, registriesToClean(nil, cleanupPolicy))
+}
+
+func Test_applyPolicy(t *testing.T) {
+	deleteUntagged := true
+	retainLatestUntagged := 0
+	retainLatestTagged := 3
+	options := cleanupOptions{registryName: "radixcache", whitelisted: []string{"radix-operator"}, retainLatestUntagged: 5}
+
+	assert.Equal(t, options, applyPolicy(options, policy.Registry{Name: "radixcache"}))
+	assert.Equal(t,
+		cleanupOptions{registryName: "radixcache", whitelisted: []string{}, deleteUntagged: true, retainLatestUntagged: 0, retainLatestTagged: 3, registryAliases: []string{"mirror.example.com"}},
+		applyPolicy(options, policy.Registry{Name: "radixcache", Whitelisted: []string{}, DeleteUntagged: &deleteUntagged, RetainLatestUntagged: &retainLatestUntagged, RetainLatestTagged: &retainLatestTagged, Aliases: []string{"mirror.example.com"}}))
+}
+
+func Test_newAzureCredential(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "azure-identity-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("service-account-token"), 0600))
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		assert.Equal(t, "/tenant/oauth2/v2.0/token", r.URL.Path)
+		assert.Equal(t, "service-account-token", r.PostFormValue("client_assertion"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+	}))
+	defer server.Close()
+
+	auth := azureAuth{method: authMethodWorkloadIdentity, tenantID: "tenant", clientID: "client-id", federatedTokenFile: tokenFile, authorityHost: server.URL}
+	credential, err := newAzureCredential(auth, http.DefaultClient)
+	require.NoError(t, err)
+	for range 2 {
+		token, err := credential.GetToken(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "token", token.AccessToken)
+	}
+	assert.Equal(t, int64(1), requests.Load(), "token should be reused until it is about to expire")
+
+	_, err = newAzureCredential(azureAuth{method: authMethodClientSecret, tenantID: "tenant"}, http.DefaultClient)
+	assert.EqualError(t, err, "--azure-credentials-file is required with auth method client-secret")
+	_, err = newAzureCredential(azureAuth{method: authMethodWorkloadIdentity, tenantID: "tenant", federatedTokenFile: tokenFile}, http.DefaultClient)
+	assert.EqualError(t, err, "--azure-client-id is required with auth method workload-identity")
+	_, err = newAzureCredential(azureAuth{method: "password"}, http.DefaultClient)
+	assert.Error(t, err)
+	_, err = newAzureCredential(azureAuth{method: authMethodManagedIdentity}, http.DefaultClient)
+	assert.NoError(t, err)
+}
+
+type stubCredential struct {
+	err error
+}
+
+func (c stubCredential) GetToken(context.Context) (aad.Token, error) {
+	if c.err != nil {
+		return aad.Token{}, c.err
+	}
+	return aad.Token{AccessToken: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func Test_readinessHandler(t *testing.T) {
+	ready := httptest.NewRecorder()
+	readinessHandler([]aad.Credential{stubCredential{}}).ServeHTTP(ready, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, ready.Code)
+
+	notReady := httptest.NewRecorder()
+	readinessHandler([]aad.Credential{stubCredential{}, stubCredential{err: errors.New("invalid_client")}}).ServeHTTP(notReady, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, notReady.Code)
+	assert.Contains(t, notReady.Body.String(), "invalid_client")
+}
+
+func newClassifier(clusterTypes manifest.ClusterTypes, rules ...manifest.Rule) *manifest.Classifier {
+	classifier, err := manifest.NewClassifier(clusterTypes, rules)
+	if err != nil {
+		panic(err)
+	}
+	return classifier
+}
+
+// evaluate Evaluates a manifest of the repository app, and returns its verdict and whether it is pending deletion as untagged
+func evaluate(candidate manifest.Data, imagesInCluster []image.Data, options cleanupOptions) (verdict, bool) {
+	pendingUntagged := make([]manifest.Data, 0)
+	verdict := evaluateManifest(context.Background(), "app", candidate, imagesInCluster, nil, options, &pendingUntagged)
+	return verdict, len(pendingUntagged) > 0
+}
+
+func Test_evaluateManifest_ConfiguredClusterTypes(t *testing.T) {
+	options := cleanupOptions{clusterType: "development", deleteUntagged: true}
+	configured := newClassifier(manifest.ClusterTypes{
+		{Name: "development", TagPrefixes: []string{"development-", "dev-39-"}},
+		{Name: "c2", TagPrefixes: []string{"c2-"}},
+	})
+
+	verdict, pending := evaluate(manifest.Data{Digest: "a", Tags: []string{"c2-a"}}, nil, options)
+	assert.Equal(t, verdictRetain, verdict)
+	assert.True(t, pending, "tagged for unknown cluster type is untagged")
+
+	options.classifier = configured
+	verdict, pending = evaluate(manifest.Data{Digest: "a", Tags: []string{"c2-a"}}, nil, options)
+	assert.Equal(t, verdictRetain, verdict)
+	assert.False(t, pending, "tagged for configured cluster type is retained")
+
+	verdict, _ = evaluate(manifest.Data{Digest: "a", Tags: []string{"dev-39-a"}}, nil, options)
+	assert.Equal(t, verdictDelete, verdict, "tagged with configured tag prefix for current cluster type is deleted")
+}
+
+func Test_evaluateManifest_TagRules(t *testing.T) {
+	options := cleanupOptions{clusterType: "development", classifier: newClassifier(nil,
+		manifest.Rule{Category: manifest.CategoryProtected, Glob: "latest"},
+		manifest.Rule{Category: manifest.CategoryRelease, Regex: `^v\d+\.\d+\.\d+$`},
+		manifest.Rule{Category: manifest.CategoryCache, Glob: "cache-*"},
+	)}
+	inUse := []image.Data{{Repository: "app", Tag: "cache-arm64"}}
+
+	tests := []struct {
+		name          string
+		tags          []string
+		expectVerdict verdict
+	}{
+		{name: "protected tag is retained", tags: []string{"latest", "development-a"}, expectVerdict: verdictRetain},
+		{name: "release tag is retained", tags: []string{"v1.2.3", "development-a"}, expectVerdict: verdictRetain},
+		{name: "release tag without cluster type tag is retained", tags: []string{"v2.0.0"}, expectVerdict: verdictRetain},
+		{name: "tag not matching a rule is left to the cluster type", tags: []string{"v1.2.3-rc1", "development-a"}, expectVerdict: verdictDelete},
+		{name: "cache tag is deleted even if untagged are not mandated for deletion", tags: []string{"cache-amd64"}, expectVerdict: verdictDelete},
+		{name: "cache tag in use is retained", tags: []string{"cache-arm64"}, expectVerdict: verdictRetain},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verdict, pending := evaluate(manifest.Data{Digest: "a", Tags: test.tags}, inUse, options)
+			assert.Equal(t, test.expectVerdict, verdict)
+			assert.False(t, pending)
+		})
+	}
+}
+
+func Test_findManifestInCluster(t *testing.T) {
+	manifest := manifest.Data{Digest: "sha256:a", Tags: []string{"development-a"}}
+	byTag := image.Data{Repository: "app", Tag: "development-a"}
+	byDigest := image.Data{Repository: "app", Tag: "development-b", Digest: "sha256:a"}
+
+	usedBy, matched := findManifestInCluster("app", manifest, []image.Data{byTag, byDigest})
+	assert.Equal(t, matchedDigest, matched)
+	assert.Equal(t, byDigest, usedBy)
+
+	usedBy, matched = findManifestInCluster("APP", manifest, []image.Data{byTag})
+	assert.Equal(t, matchedTag, matched)
+	assert.Equal(t, byTag, usedBy)
+
+	_, matched = findManifestInCluster("app", manifest, []image.Data{{Repository: "app", Tag: "development-a", Digest: "sha256:b"}})
+	assert.Equal(t, matchedNone, matched)
+	_, matched = findManifestInCluster("other", manifest, []image.Data{byTag, byDigest})
+	assert.Equal(t, matchedNone, matched)
+}
+
+func Test_evaluateManifest_PinnedByDigest(t *testing.T) {
+	options := cleanupOptions{clusterType: "development"}
+	tagged := manifest.Data{Digest: "sha256:a", Tags: []string{"development-a"}}
+
+	verdict, _ := evaluate(tagged, []image.Data{{Repository: "app", Digest: "sha256:a"}}, options)
+	assert.Equal(t, verdictRetain, verdict, "pinned by digest is retained")
+
+	verdict, _ = evaluate(tagged, []image.Data{{Repository: "app", Tag: "development-a", Digest: "sha256:b"}}, options)
+	assert.Equal(t, verdictDelete, verdict, "pinned by digest is matched by digest only, even if its tag has moved")
+}
+
+func Test_cleanupRegistry_IgnoresImagesOfOtherRegistries(t *testing.T) {
+	start := time.Now()
+	old := start.Add(-48 * time.Hour)
+	reg := fake.New().AddManifests("app",
+		manifest.Data{Digest: "a", Tags: []string{"development-a"}, LastUpdateTime: old},
+		manifest.Data{Digest: "b", Tags: []string{"development-b"}, LastUpdateTime: old},
+		manifest.Data{Digest: "c", Tags: []string{"development-c"}, LastUpdateTime: old},
+		manifest.Data{Digest: "d", Tags: []string{"development-d"}, LastUpdateTime: old},
+	)
+	imagesInCluster := []image.Data{
+		{Registry: "docker.io", Repository: "app", Tag: "development-a"},
+		{Registry: "RADIXDEV.azurecr.io", Repository: "app", Tag: "development-b"},
+		{Registry: "mirror.example.com", Repository: "app", Tag: "development-c"},
+	}
+	options := cleanupOptions{registryName: "radixdev", loginServer: "radixdev.azurecr.io", registryAliases: []string{"mirror.example.com"}, clusterType: "development", performDelete: true}
+
+	cleanupRegistry(context.Background(), reg, imagesInCluster, start, options)
+
+	assert.Equal(t, []fake.Call{
+		{Method: fake.DeleteManifest, Repository: "app", Digest: "a"},
+		{Method: fake.DeleteManifest, Repository: "app", Digest: "d"},
+	}, reg.CallsTo(fake.DeleteManifest))
+}
+
+func Test_imagesInRegistry(t *testing.T) {
+	own := image.Data{Registry: "RADIXDEV.azurecr.io", Repository: "app", Tag: "development-a"}
+	mirrored := image.Data{Registry: "mirror.example.com", Repository: "app", Tag: "development-b"}
+	foreign := image.Data{Registry: "docker.io", Repository: "library/busybox", Tag: "latest"}
+	imagesInCluster := []image.Data{own, mirrored, foreign}
+
+	assert.Equal(t, imagesInCluster, imagesInRegistry(imagesInCluster, cleanupOptions{}), "all images without login server")
+	assert.Equal(t, []image.Data{own}, imagesInRegistry(imagesInCluster, cleanupOptions{loginServer: "radixdev.azurecr.io"}))
+	assert.Equal(t, []image.Data{own, mirrored}, imagesInRegistry(imagesInCluster, cleanupOptions{loginServer: "radixdev.azurecr.io", registryAliases: []string{" mirror.example.com"}}))
+	assert.Empty(t, imagesInRegistry([]image.Data{foreign}, cleanupOptions{loginServer: "radixdev.azurecr.io"}))
+}
+
+type stubSource struct {
+	images []image.Data
+	err    error
+}
+
+func (s stubSource) Name() string { return "stub" }
+
+func (s stubSource) Images(context.Context) ([]image.Data, error) { return s.images, s.err }
+
+func Test_listActiveImagesInCluster(t *testing.T) {
+	web := image.Data{Registry: "radixdev.azurecr.io", Repository: "app-web", Tag: "development-1"}
+	job := image.Data{Registry: "radixdev.azurecr.io", Repository: "app-job", Tag: "development-1"}
+	pinned := image.Data{Registry: "radixdev.azurecr.io", Repository: "app-web", Digest: "sha256:a"}
+
+	images, err := listActiveImagesInCluster(context.Background(), []usage.Source{
+		stubSource{images: []image.Data{web, job}},
+		stubSource{images: []image.Data{web, pinned, pinned}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []image.Data{web, job, pinned}, images)
+
+	_, err = listActiveImagesInCluster(context.Background(), []usage.Source{
+		stubSource{images: []image.Data{web}},
+		stubSource{err: errors.New("forbidden")},
+	})
+	assert.Error(t, err)
 }
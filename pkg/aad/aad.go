@@ -0,0 +1,140 @@
+package aad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultAuthorityHost Microsoft Entra ID authority for the public cloud
+	DefaultAuthorityHost = "https://login.microsoftonline.com"
+	// ContainerRegistryScope Scope of AAD tokens exchanged for ACR refresh tokens
+	ContainerRegistryScope = "https://containerregistry.azure.net/.default"
+)
+
+// Token AAD access token and its expiry
+type Token struct {
+	AccessToken string
+	ExpiresOn   time.Time
+}
+
+// Credential Acquires AAD access tokens
+type Credential interface {
+	GetToken(ctx context.Context) (Token, error)
+}
+
+// ServicePrincipal Credentials as stored in the service principal credentials file
+type ServicePrincipal struct {
+	ID       string `json:"id"`
+	Password string `json:"password"`
+}
+
+// ReadServicePrincipalFile Reads service principal id and password from a JSON file
+func ReadServicePrincipalFile(path string) (*ServicePrincipal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read service principal file %s failed: %w", path, err)
+	}
+
+	var sp ServicePrincipal
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return nil, fmt.Errorf("parse service principal file %s failed: %w", path, err)
+	}
+
+	if len(sp.ID) == 0 || len(sp.Password) == 0 {
+		return nil, fmt.Errorf("service principal file %s must contain id and password", path)
+	}
+
+	return &sp, nil
+}
+
+// ClientSecretCredential Acquires AAD tokens with the client credentials grant using a client secret
+type ClientSecretCredential struct {
+	TenantID      string
+	ClientID      string
+	ClientSecret  string
+	Scope         string
+	AuthorityHost string
+	HTTPClient    *http.Client
+}
+
+// NewClientSecretCredential Constructor for ClientSecretCredential
+func NewClientSecretCredential(tenantID, clientID, clientSecret string) *ClientSecretCredential {
+	return &ClientSecretCredential{
+		TenantID:      tenantID,
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		Scope:         ContainerRegistryScope,
+		AuthorityHost: DefaultAuthorityHost,
+		HTTPClient:    http.DefaultClient,
+	}
+}
+
+// GetToken Requests a new access token from the authority
+func (c *ClientSecretCredential) GetToken(ctx context.Context) (Token, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"scope":         {c.Scope},
+	}
+	return requestToken(ctx, c.HTTPClient, tokenEndpoint(c.AuthorityHost, c.TenantID), form)
+}
+
+func tokenEndpoint(authorityHost, tenantID string) string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), url.PathEscape(tenantID))
+}
+
+// tokenResponse Response of both the token endpoint and IMDS, where the latter returns expires_in as a string
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+func requestToken(ctx context.Context, client *http.Client, endpoint string, form url.Values) (Token, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return doTokenRequest(client, req)
+}
+
+func doTokenRequest(client *http.Client, req *http.Request) (Token, error) {
+	endpoint := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	resp, err := client.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("token request to %s failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Token{}, fmt.Errorf("read token response from %s failed: %w", endpoint, err)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return Token{}, fmt.Errorf("token request to %s returned %s: %w", endpoint, resp.Status, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || len(tr.AccessToken) == 0 {
+		return Token{}, fmt.Errorf("token request to %s returned %s: %s %s", endpoint, resp.Status, tr.Error, tr.ErrorDescription)
+	}
+
+	expiresIn, _ := tr.ExpiresIn.Int64()
+	return Token{
+		AccessToken: tr.AccessToken,
+		ExpiresOn:   time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,55 @@
+package aad
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadServicePrincipalFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sp_credentials.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":"client-id","password":"secret"}`), 0600))
+
+	sp, err := ReadServicePrincipalFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "client-id", sp.ID)
+	assert.Equal(t, "secret", sp.Password)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":"client-id"}`), 0600))
+	_, err = ReadServicePrincipalFile(path)
+	assert.Error(t, err)
+}
+
+func TestClientSecretCredential(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tenant/oauth2/v2.0/token", r.URL.Path)
+		if r.PostFormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		assert.Equal(t, "client_credentials", r.PostFormValue("grant_type"))
+		assert.Equal(t, ContainerRegistryScope, r.PostFormValue("scope"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+	}))
+	defer server.Close()
+
+	credential := NewClientSecretCredential("tenant", "client-id", "secret")
+	credential.AuthorityHost = server.URL
+	token, err := credential.GetToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.ExpiresOn, time.Minute)
+
+	credential.ClientSecret = "wrong"
+	_, err = credential.GetToken(context.Background())
+	assert.ErrorContains(t, err, "invalid_client")
+}
@@ -0,0 +1,41 @@
+package aad
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Tokens are refreshed this long before they expire
+const tokenRefreshMargin = 5 * time.Minute
+
+// CachingCredential Reuses the token of another credential until it is about to expire
+type CachingCredential struct {
+	credential Credential
+
+	mu    sync.Mutex
+	token Token
+}
+
+// NewCachingCredential Constructor for CachingCredential
+func NewCachingCredential(credential Credential) *CachingCredential {
+	return &CachingCredential{credential: credential}
+}
+
+// GetToken Returns the cached token, or requests a new one if it is about to expire
+func (c *CachingCredential) GetToken(ctx context.Context) (Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.token.AccessToken) > 0 && time.Now().Add(tokenRefreshMargin).Before(c.token.ExpiresOn) {
+		return c.token, nil
+	}
+
+	token, err := c.credential.GetToken(ctx)
+	if err != nil {
+		c.token = Token{}
+		return Token{}, err
+	}
+	c.token = token
+	return token, nil
+}
@@ -0,0 +1,45 @@
+package aad
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingCredential struct {
+	calls     int
+	expiresIn time.Duration
+	err       error
+}
+
+func (c *countingCredential) GetToken(context.Context) (Token, error) {
+	c.calls++
+	if c.err != nil {
+		return Token{}, c.err
+	}
+	return Token{AccessToken: "token", ExpiresOn: time.Now().Add(c.expiresIn)}, nil
+}
+
+func TestCachingCredential(t *testing.T) {
+	source := &countingCredential{expiresIn: time.Hour}
+	credential := NewCachingCredential(source)
+
+	for range 3 {
+		token, err := credential.GetToken(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "token", token.AccessToken)
+	}
+	assert.Equal(t, 1, source.calls)
+
+	source.err = errors.New("invalid_client")
+	credential.token.ExpiresOn = time.Now().Add(time.Minute)
+	_, err := credential.GetToken(context.Background())
+	assert.Error(t, err, "token about to expire should be refreshed")
+	_, err = credential.GetToken(context.Background())
+	assert.Error(t, err, "failed refresh should not be cached")
+	assert.Equal(t, 3, source.calls)
+}
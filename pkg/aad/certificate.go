@@ -0,0 +1,137 @@
+package aad
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Lifetime of the client assertion signed with the certificate
+const assertionLifetime = 10 * time.Minute
+
+// ClientCertificateCredential Acquires AAD tokens with a client assertion signed by the private key of a certificate
+type ClientCertificateCredential struct {
+	TenantID      string
+	ClientID      string
+	Certificate   *x509.Certificate
+	PrivateKey    *rsa.PrivateKey
+	Scope         string
+	AuthorityHost string
+	HTTPClient    *http.Client
+}
+
+// NewClientCertificateCredential Constructor for ClientCertificateCredential
+func NewClientCertificateCredential(tenantID, clientID string, certificate *x509.Certificate, privateKey *rsa.PrivateKey) *ClientCertificateCredential {
+	return &ClientCertificateCredential{
+		TenantID:      tenantID,
+		ClientID:      clientID,
+		Certificate:   certificate,
+		PrivateKey:    privateKey,
+		Scope:         ContainerRegistryScope,
+		AuthorityHost: DefaultAuthorityHost,
+		HTTPClient:    http.DefaultClient,
+	}
+}
+
+// ReadCertificateFile Reads a certificate and its RSA private key from a PEM file
+func ReadCertificateFile(path string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read certificate file %s failed: %w", path, err)
+	}
+
+	var certificate *x509.Certificate
+	var privateKey *rsa.PrivateKey
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if certificate == nil {
+				if certificate, err = x509.ParseCertificate(block.Bytes); err != nil {
+					return nil, nil, fmt.Errorf("parse certificate in %s failed: %w", path, err)
+				}
+			}
+		case "RSA PRIVATE KEY":
+			if privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, nil, fmt.Errorf("parse private key in %s failed: %w", path, err)
+			}
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse private key in %s failed: %w", path, err)
+			}
+			var ok bool
+			if privateKey, ok = key.(*rsa.PrivateKey); !ok {
+				return nil, nil, fmt.Errorf("private key in %s is not an RSA key", path)
+			}
+		}
+	}
+
+	if certificate == nil || privateKey == nil {
+		return nil, nil, fmt.Errorf("certificate file %s must contain a certificate and its private key", path)
+	}
+	return certificate, privateKey, nil
+}
+
+// GetToken Requests a new access token from the authority
+func (c *ClientCertificateCredential) GetToken(ctx context.Context) (Token, error) {
+	endpoint := tokenEndpoint(c.AuthorityHost, c.TenantID)
+	assertion, err := c.clientAssertion(endpoint, time.Now())
+	if err != nil {
+		return Token{}, err
+	}
+
+	return requestToken(ctx, c.HTTPClient, endpoint, clientAssertionForm(c.ClientID, assertion, c.Scope))
+}
+
+// clientAssertion Signs a JWT with RS256, identifying the certificate by its SHA-1 thumbprint
+func (c *ClientCertificateCredential) clientAssertion(audience string, now time.Time) (string, error) {
+	thumbprint := sha1.Sum(c.Certificate.Raw)
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	claims := map[string]interface{}{
+		"aud": audience,
+		"iss": c.ClientID,
+		"sub": c.ClientID,
+		"jti": hex.EncodeToString(id),
+		"nbf": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	}
+
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(encodedClaims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.PrivateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("sign client assertion failed: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
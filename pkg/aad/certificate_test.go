@@ -0,0 +1,111 @@
+package aad
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCertificateFile(t *testing.T) (string, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "radix-acr-cleanup"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "client.pem")
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path, certificate
+}
+
+func TestReadCertificateFile(t *testing.T) {
+	path, expected := writeCertificateFile(t)
+
+	certificate, privateKey, err := ReadCertificateFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Raw, certificate.Raw)
+	assert.True(t, privateKey.PublicKey.Equal(expected.PublicKey))
+
+	onlyCertificate := filepath.Join(t.TempDir(), "certificate.pem")
+	require.NoError(t, os.WriteFile(onlyCertificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: expected.Raw}), 0600))
+	_, _, err = ReadCertificateFile(onlyCertificate)
+	assert.Error(t, err)
+}
+
+func TestClientCertificateCredential(t *testing.T) {
+	path, certificate := writeCertificateFile(t)
+	var endpoint string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, clientAssertionType, r.PostFormValue("client_assertion_type"))
+		parts := strings.Split(r.PostFormValue("client_assertion"), ".")
+		require.Len(t, parts, 3)
+
+		var header map[string]string
+		decoded, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		require.NoError(t, json.Unmarshal(decoded, &header))
+		thumbprint := sha1.Sum(certificate.Raw)
+		assert.Equal(t, "RS256", header["alg"])
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(thumbprint[:]), header["x5t"])
+
+		var claims map[string]interface{}
+		decoded, _ = base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, json.Unmarshal(decoded, &claims))
+		assert.Equal(t, endpoint, claims["aud"])
+		assert.Equal(t, "client-id", claims["iss"])
+		assert.Equal(t, "client-id", claims["sub"])
+
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, hash[:], signature); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+	}))
+	defer server.Close()
+	endpoint = server.URL + "/tenant/oauth2/v2.0/token"
+
+	cert, privateKey, err := ReadCertificateFile(path)
+	require.NoError(t, err)
+	credential := NewClientCertificateCredential("tenant", "client-id", cert, privateKey)
+	credential.AuthorityHost = server.URL
+	token, err := credential.GetToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	credential.PrivateKey = otherKey
+	_, err = credential.GetToken(context.Background())
+	assert.ErrorContains(t, err, "invalid_client")
+}
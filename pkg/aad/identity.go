@@ -0,0 +1,101 @@
+package aad
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultIMDSEndpoint Token endpoint of the Azure Instance Metadata Service
+	DefaultIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// ContainerRegistryResource Resource of IMDS tokens exchanged for ACR refresh tokens
+	ContainerRegistryResource = "https://containerregistry.azure.net"
+
+	imdsAPIVersion      = "2018-02-01"
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// WorkloadIdentityCredential Acquires AAD tokens with a federated Kubernetes service account token as client assertion
+type WorkloadIdentityCredential struct {
+	TenantID      string
+	ClientID      string
+	TokenFilePath string
+	Scope         string
+	AuthorityHost string
+	HTTPClient    *http.Client
+}
+
+// NewWorkloadIdentityCredential Constructor for WorkloadIdentityCredential
+func NewWorkloadIdentityCredential(tenantID, clientID, tokenFilePath string) *WorkloadIdentityCredential {
+	return &WorkloadIdentityCredential{
+		TenantID:      tenantID,
+		ClientID:      clientID,
+		TokenFilePath: tokenFilePath,
+		Scope:         ContainerRegistryScope,
+		AuthorityHost: DefaultAuthorityHost,
+		HTTPClient:    http.DefaultClient,
+	}
+}
+
+// GetToken Requests a new access token from the authority. The token file is read each time, as kubelet rotates it
+func (c *WorkloadIdentityCredential) GetToken(ctx context.Context) (Token, error) {
+	assertion, err := os.ReadFile(c.TokenFilePath)
+	if err != nil {
+		return Token{}, fmt.Errorf("read federated token file %s failed: %w", c.TokenFilePath, err)
+	}
+
+	form := clientAssertionForm(c.ClientID, strings.TrimSpace(string(assertion)), c.Scope)
+	return requestToken(ctx, c.HTTPClient, tokenEndpoint(c.AuthorityHost, c.TenantID), form)
+}
+
+// ManagedIdentityCredential Acquires AAD tokens for a managed identity from the Instance Metadata Service
+type ManagedIdentityCredential struct {
+	// ClientID Selects a user-assigned identity. Empty means the system-assigned identity
+	ClientID   string
+	Resource   string
+	Endpoint   string
+	HTTPClient *http.Client
+}
+
+// NewManagedIdentityCredential Constructor for ManagedIdentityCredential
+func NewManagedIdentityCredential(clientID string) *ManagedIdentityCredential {
+	return &ManagedIdentityCredential{
+		ClientID:   clientID,
+		Resource:   ContainerRegistryResource,
+		Endpoint:   DefaultIMDSEndpoint,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// GetToken Requests a new access token from IMDS
+func (c *ManagedIdentityCredential) GetToken(ctx context.Context) (Token, error) {
+	query := url.Values{
+		"api-version": {imdsAPIVersion},
+		"resource":    {c.Resource},
+	}
+	if len(c.ClientID) > 0 {
+		query.Set("client_id", c.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	return doTokenRequest(c.HTTPClient, req)
+}
+
+func clientAssertionForm(clientID, assertion, scope string) url.Values {
+	return url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	}
+}
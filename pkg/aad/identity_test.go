@@ -0,0 +1,77 @@
+package aad
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkloadIdentityCredential(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tenant/oauth2/v2.0/token", r.URL.Path)
+		assert.Equal(t, "client_credentials", r.PostFormValue("grant_type"))
+		assert.Equal(t, "client-id", r.PostFormValue("client_id"))
+		assert.Equal(t, clientAssertionType, r.PostFormValue("client_assertion_type"))
+		assert.Equal(t, ContainerRegistryScope, r.PostFormValue("scope"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token-for-" + r.PostFormValue("client_assertion"), "expires_in": 3600})
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "azure-identity-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("first\n"), 0600))
+	credential := NewWorkloadIdentityCredential("tenant", "client-id", tokenFile)
+	credential.AuthorityHost = server.URL
+
+	token, err := credential.GetToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-for-first", token.AccessToken)
+
+	require.NoError(t, os.WriteFile(tokenFile, []byte("rotated"), 0600))
+	token, err = credential.GetToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-for-rotated", token.AccessToken, "rotated token file should be read again")
+
+	credential.TokenFilePath = filepath.Join(t.TempDir(), "missing")
+	_, err = credential.GetToken(context.Background())
+	assert.Error(t, err)
+}
+
+func TestManagedIdentityCredential(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request", "error_description": "Required metadata header not specified"})
+			return
+		}
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, imdsAPIVersion, r.URL.Query().Get("api-version"))
+		assert.Equal(t, ContainerRegistryResource, r.URL.Query().Get("resource"))
+		if clientID := r.URL.Query().Get("client_id"); clientID != "client-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request", "error_description": "Identity not found"})
+			return
+		}
+		// IMDS returns expires_in as a string
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "expires_in": "3600"})
+	}))
+	defer server.Close()
+
+	credential := NewManagedIdentityCredential("client-id")
+	credential.Endpoint = server.URL + "/metadata/identity/oauth2/token"
+	token, err := credential.GetToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.ExpiresOn, time.Minute)
+
+	credential.ClientID = "unknown"
+	_, err = credential.GetToken(context.Background())
+	assert.ErrorContains(t, err, "Identity not found")
+}
@@ -1,16 +1,24 @@
 package acr
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-
-	"github.com/equinor/radix-acr-cleanup/pkg/logwriter"
-	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"iter"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	loginServerSuffix = ".azurecr.io"
+	pageSize          = 100
 )
 
 // ListRepositoriesError error
@@ -18,114 +26,299 @@ func ListRepositoriesError(registry string, cause error) error {
 	return fmt.Errorf("list repositories for registry %s failed: %w", registry, cause)
 }
 
+// GetRepositoryError error
+func GetRepositoryError(repository string, cause error) error {
+	return fmt.Errorf("get attributes of repository %s failed: %w", repository, cause)
+}
+
 // ListManifestsError error
 func ListManifestsError(repository string, cause error) error {
 	return fmt.Errorf("list manifests for repository %s failed: %w", repository, cause)
 }
 
-// ListRepositories Is all available repositories in provided ACR registry
-func ListRepositories(registry string) ([]string, error) {
-	listCmd := newListRepositoriesCommand(registry)
+// ListIndexChildrenError error
+func ListIndexChildrenError(repository, digest string, cause error) error {
+	return fmt.Errorf("list children of index %s in repository %s failed: %w", digest, repository, cause)
+}
 
-	var outb bytes.Buffer
-	listCmd.Stdout = &outb
+// ListReferrersError error
+func ListReferrersError(repository, digest string, cause error) error {
+	return fmt.Errorf("list referrers of %s in repository %s failed: %w", digest, repository, cause)
+}
 
-	if err := listCmd.Run(); err != nil {
-		return nil, ListRepositoriesError(registry, err)
+// DeleteManifestError error
+func DeleteManifestError(repository, digest string, cause error) error {
+	return fmt.Errorf("delete manifest %s in repository %s failed: %w", digest, repository, cause)
+}
+
+// UntagError error
+func UntagError(repository, tag string, cause error) error {
+	return fmt.Errorf("untag %s in repository %s failed: %w", tag, repository, cause)
+}
+
+// DeleteRepositoryError error
+func DeleteRepositoryError(repository string, cause error) error {
+	return fmt.Errorf("delete repository %s failed: %w", repository, cause)
+}
+
+// LoginServer Returns the login server of an ACR registry name, e.g. radixdev.azurecr.io
+func LoginServer(registry string) string {
+	if strings.Contains(registry, ".") {
+		return strings.ToLower(registry)
 	}
+	return strings.ToLower(registry) + loginServerSuffix
+}
 
-	repos, err := getRepositoriesFromStringData(outb.String())
-	if err != nil {
-		return nil, ListRepositoriesError(registry, err)
+// Option Configures a Client
+type Option func(*Client)
+
+// WithHTTPClient Sets the HTTP client used for registry and token requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
 	}
+}
 
-	return repos, nil
+// WithTenantID Sets the AAD tenant passed to the ACR token exchange
+func WithTenantID(tenantID string) Option {
+	return func(c *Client) {
+		c.tenantID = tenantID
+	}
 }
 
-// ListManifests Lists all available manifests for a single repository
-func ListManifests(registry, repository string) ([]manifest.Data, error) {
-	listCmd := newListManifestsCommand(registry, repository)
+// Client Talks to the ACR data-plane REST API
+type Client struct {
+	loginServer string
+	baseURL     string
+	tenantID    string
+	credential  TokenCredential
+	httpClient  *http.Client
 
-	var outb bytes.Buffer
-	listCmd.Stdout = &outb
+	mu           sync.Mutex
+	refreshToken cachedToken
+	accessTokens map[string]cachedToken
+	// exchanges Token exchanges in progress, by scope
+	exchanges singleflight.Group
+}
 
-	if err := listCmd.Run(); err != nil {
-		return nil, ListManifestsError(repository, err)
+var _ registry.Registry = &Client{}
+
+// NewClient Constructor for a Client authenticating to loginServer with AAD tokens from credential
+func NewClient(loginServer string, credential TokenCredential, options ...Option) *Client {
+	c := &Client{
+		loginServer:  loginServer,
+		baseURL:      "https://" + loginServer,
+		credential:   credential,
+		httpClient:   http.DefaultClient,
+		accessTokens: make(map[string]cachedToken),
 	}
 
-	manifests, err := manifest.FromDataSorted(outb.Bytes())
-	if err != nil {
-		return nil, ListManifestsError(repository, err)
+	for _, option := range options {
+		option(c)
 	}
 
-	return manifests, nil
+	return c
 }
 
-// DeleteManifest Will delete a single manifest
-func DeleteManifest(registry, repository string, manifest manifest.Data) error {
-	// Will perform an actual delete
-	deleteCmd := newDeleteManifestsCommand(registry, repository, manifest.Digest)
+// ListRepositories Is all available repositories in the registry, fetched one page at a time
+func (c *Client) ListRepositories(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		next := fmt.Sprintf("/acr/v1/_catalog?n=%d", pageSize)
+		for len(next) > 0 {
+			var page struct {
+				Repositories []string `json:"repositories"`
+			}
+
+			link, err := c.getJSON(ctx, next, catalogScope, &page)
+			if err != nil {
+				yield("", ListRepositoriesError(c.loginServer, err))
+				return
+			}
+
+			for _, repository := range page.Repositories {
+				if !yield(repository, nil) {
+					return
+				}
+			}
+			next = link
+		}
+	}
+}
+
+// GetRepository Gets the attributes of a single repository, including its lock attributes
+func (c *Client) GetRepository(ctx context.Context, repository string) (registry.Repository, error) {
+	var attributes struct {
+		LastUpdateTime       time.Time                     `json:"lastUpdateTime"`
+		ManifestCount        int                           `json:"manifestCount"`
+		ChangeableAttributes manifest.ChangeableAttributes `json:"changeableAttributes"`
+	}
 
-	var outb bytes.Buffer
-	deleteCmd.Stdout = &outb
+	if _, err := c.getJSON(ctx, "/acr/v1/"+repository, repositoryScope(repository, "metadata_read"), &attributes); err != nil {
+		return registry.Repository{}, GetRepositoryError(repository, err)
+	}
 
-	return deleteCmd.Run()
+	return registry.Repository{
+		Name:                 repository,
+		LastUpdateTime:       attributes.LastUpdateTime,
+		ManifestCount:        attributes.ManifestCount,
+		ChangeableAttributes: attributes.ChangeableAttributes,
+	}, nil
 }
 
-func newListRepositoriesCommand(registry string) *exec.Cmd {
-	args := []string{"acr", "repository", "list",
-		"--name", registry}
+// ListManifests Lists all available manifests for a single repository ordered by timestamp asc, fetched one page at a time
+func (c *Client) ListManifests(ctx context.Context, repository string) iter.Seq2[manifest.Data, error] {
+	return func(yield func(manifest.Data, error) bool) {
+		next := fmt.Sprintf("/acr/v1/%s/_manifests?orderby=timeasc&n=%d", repository, pageSize)
+		for len(next) > 0 {
+			var page struct {
+				Manifests []manifest.Data `json:"manifests"`
+			}
 
-	cmd := exec.Command("az", args...)
-	logger := log.With().
-		Str("cmd", cmd.Args[0]).
-		Str("std", "err").
-		Logger()
+			link, err := c.getJSON(ctx, next, repositoryScope(repository, "metadata_read"), &page)
+			if err != nil {
+				yield(manifest.Data{}, ListManifestsError(repository, err))
+				return
+			}
 
-	cmd.Stderr = logwriter.New(&logger, zerolog.WarnLevel)
+			for _, m := range page.Manifests {
+				if !yield(m, nil) {
+					return
+				}
+			}
+			next = link
+		}
+	}
+}
+
+// ListIndexChildren Lists the digests of the platform manifests referenced by an image index
+func (c *Client) ListIndexChildren(ctx context.Context, repository, digest string) ([]string, error) {
+	var index struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
 
-	return cmd
+	path := fmt.Sprintf("/v2/%s/manifests/%s", repository, digest)
+	if _, err := c.getJSONAccept(ctx, path, repositoryScope(repository, "pull"), strings.Join(manifest.IndexMediaTypes, ", "), &index); err != nil {
+		return nil, ListIndexChildrenError(repository, digest, err)
+	}
+
+	children := make([]string, 0, len(index.Manifests))
+	for _, child := range index.Manifests {
+		children = append(children, child.Digest)
+	}
+	return children, nil
 }
 
-func getRepositoriesFromStringData(data string) ([]string, error) {
-	repositories := make([]string, 0)
-	err := json.Unmarshal([]byte(data), &repositories)
+// ListReferrers Lists the digests of the manifests attached to a subject through the referrers API,
+// fetched one page at a time
+func (c *Client) ListReferrers(ctx context.Context, repository, digest string) ([]string, error) {
+	referrers := make([]string, 0)
+	next := fmt.Sprintf("/v2/%s/referrers/%s", repository, digest)
+	for len(next) > 0 {
+		var page struct {
+			Manifests []struct {
+				Digest string `json:"digest"`
+			} `json:"manifests"`
+		}
+
+		link, err := c.getJSONAccept(ctx, next, repositoryScope(repository, "pull"), manifest.MediaTypeOCIIndex, &page)
+		if err != nil {
+			return nil, ListReferrersError(repository, digest, err)
+		}
+
+		for _, referrer := range page.Manifests {
+			referrers = append(referrers, referrer.Digest)
+		}
+		next = link
+	}
+	return referrers, nil
+}
+
+// DeleteManifest Will delete a single manifest
+func (c *Client) DeleteManifest(ctx context.Context, repository string, manifest manifest.Data) error {
+	path := fmt.Sprintf("/v2/%s/manifests/%s", repository, manifest.Digest)
+
+	resp, err := c.do(ctx, http.MethodDelete, path, repositoryScope(repository, "delete"), "application/json")
 	if err != nil {
-		return nil, err
+		return DeleteManifestError(repository, manifest.Digest, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return DeleteManifestError(repository, manifest.Digest, registry.ResponseError(resp))
 	}
-	return repositories, nil
+
+	return nil
+}
+
+// Untag Removes a single tag, leaving the manifest in place
+func (c *Client) Untag(ctx context.Context, repository, tag string) error {
+	path := fmt.Sprintf("/acr/v1/%s/_tags/%s", repository, url.PathEscape(tag))
+
+	resp, err := c.do(ctx, http.MethodDelete, path, repositoryScope(repository, "delete"), "application/json")
+	if err != nil {
+		return UntagError(repository, tag, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return UntagError(repository, tag, registry.ResponseError(resp))
+	}
+
+	return nil
 }
 
-func newListManifestsCommand(registry, repository string) *exec.Cmd {
-	args := []string{"acr", "manifest", "list-metadata",
-		"--registry", registry,
-		"--name", repository,
-		"--orderby", "time_asc"}
+// DeleteRepository Deletes a repository with all its manifests and tags
+func (c *Client) DeleteRepository(ctx context.Context, repository string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/acr/v1/"+repository, repositoryScope(repository, "delete"), "application/json")
+	if err != nil {
+		return DeleteRepositoryError(repository, err)
+	}
+	defer resp.Body.Close()
 
-	cmd := exec.Command("az", args...)
-	logger := log.With().
-		Str("cmd", cmd.Args[0]).
-		Str("std", "err").
-		Logger()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return DeleteRepositoryError(repository, registry.ResponseError(resp))
+	}
 
-	cmd.Stderr = logwriter.New(&logger, zerolog.WarnLevel)
+	return nil
+}
 
-	return cmd
+// getJSON Decodes the response of a GET request into target and returns the path of the next page, if any
+func (c *Client) getJSON(ctx context.Context, path, scope string, target interface{}) (string, error) {
+	return c.getJSONAccept(ctx, path, scope, "application/json", target)
 }
 
-func newDeleteManifestsCommand(registry, repository, digest string) *exec.Cmd {
-	args := []string{"acr", "repository", "delete",
-		"--name", registry,
-		"--image", fmt.Sprintf("%s@%s", repository, digest),
-		"--yes"}
+func (c *Client) getJSONAccept(ctx context.Context, path, scope, accept string, target interface{}) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, path, scope, accept)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", registry.ResponseError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return "", err
+	}
 
-	cmd := exec.Command("az", args...)
-	logger := log.With().
-		Str("cmd", cmd.Args[0]).
-		Str("std", "err").
-		Logger()
+	return registry.NextLink(resp.Header.Get("Link")), nil
+}
 
-	cmd.Stderr = logwriter.New(&logger, zerolog.WarnLevel)
+func (c *Client) do(ctx context.Context, method, path, scope, accept string) (*http.Response, error) {
+	token, err := c.accessToken(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", accept)
 
-	return cmd
+	return registry.Do(c.httpClient, req)
 }
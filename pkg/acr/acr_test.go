@@ -0,0 +1,315 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/aad"
+	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticCredential struct{}
+
+func (staticCredential) GetToken(context.Context) (aad.Token, error) {
+	return aad.Token{AccessToken: "aad-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeRegistry Minimal stand-in for the ACR data-plane API
+type fakeRegistry struct {
+	mu          sync.Mutex
+	exchanges   int
+	scopes      []string
+	deleted     []string
+	pageSize    int
+	repos       []string
+	manifests   map[string][]manifest.Data
+	attributes  map[string]manifest.ChangeableAttributes
+	indexes     map[string][]string
+	accessToken string
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{pageSize: 2, accessToken: "acr-access-token", manifests: make(map[string][]manifest.Data), attributes: make(map[string]manifest.ChangeableAttributes), indexes: make(map[string][]string)}
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/oauth2/exchange":
+		f.exchanges++
+		if r.PostFormValue("access_token") != "aad-token" || r.PostFormValue("grant_type") != "access_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, map[string]string{"refresh_token": "acr-refresh-token"})
+	case r.URL.Path == "/oauth2/token":
+		if r.PostFormValue("refresh_token") != "acr-refresh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.scopes = append(f.scopes, r.PostFormValue("scope"))
+		writeJSON(w, map[string]string{"access_token": f.accessToken})
+	case r.Header.Get("Authorization") != "Bearer "+f.accessToken:
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.URL.Path == "/acr/v1/_catalog":
+		start := 0
+		if last := r.URL.Query().Get("last"); len(last) > 0 {
+			for i, repo := range f.repos {
+				if repo == last {
+					start = i + 1
+				}
+			}
+		}
+		end := min(start+f.pageSize, len(f.repos))
+		page := f.repos[start:end]
+		if end < len(f.repos) {
+			w.Header().Set("Link", `</acr/v1/_catalog?last=`+page[len(page)-1]+`&n=2>; rel="next"`)
+		}
+		writeJSON(w, map[string]interface{}{"repositories": page})
+	case strings.HasPrefix(r.URL.Path, "/acr/v1/") && strings.HasSuffix(r.URL.Path, "/_manifests"):
+		repo := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/acr/v1/"), "/_manifests")
+		manifests, ok := f.manifests[repo]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sorted := slices.Clone(manifests)
+		if r.URL.Query().Get("orderby") == "timeasc" {
+			manifest.SortByLastUpdateTime(sorted)
+		}
+		start := 0
+		if last := r.URL.Query().Get("last"); len(last) > 0 {
+			start = slices.IndexFunc(sorted, func(m manifest.Data) bool { return m.Digest == last }) + 1
+		}
+		end := min(start+f.pageSize, len(sorted))
+		page := sorted[start:end]
+		if end < len(sorted) {
+			w.Header().Set("Link", `</acr/v1/`+repo+`/_manifests?orderby=timeasc&last=`+page[len(page)-1].Digest+`&n=2>; rel="next"`)
+		}
+		writeJSON(w, map[string]interface{}{"imageName": repo, "manifests": page})
+	case r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/_tags/"):
+		f.deleted = append(f.deleted, strings.TrimPrefix(r.URL.Path, "/acr/v1/"))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/acr/v1/"):
+		f.deleted = append(f.deleted, strings.TrimPrefix(r.URL.Path, "/acr/v1/"))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v2/"):
+		f.deleted = append(f.deleted, strings.TrimPrefix(r.URL.Path, "/v2/"))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/"):
+		children, ok := f.indexes[r.URL.Path]
+		if !ok || !strings.Contains(r.Header.Get("Accept"), manifest.MediaTypeOCIIndex) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		descriptors := make([]map[string]string, 0)
+		for _, child := range children {
+			descriptors = append(descriptors, map[string]string{"mediaType": manifest.MediaTypeOCIManifest, "digest": child})
+		}
+		w.Header().Set("Content-Type", manifest.MediaTypeOCIIndex)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"mediaType": manifest.MediaTypeOCIIndex, "manifests": descriptors})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/acr/v1/"):
+		repo := strings.TrimPrefix(r.URL.Path, "/acr/v1/")
+		if _, ok := f.manifests[repo]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]interface{}{"errors": []map[string]string{{"code": "NAME_UNKNOWN", "message": "repository name not known to registry"}}})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"imageName": repo, "lastUpdateTime": "2019-10-30T07:38:55.8812664Z", "manifestCount": len(f.manifests[repo]), "changeableAttributes": f.attributes[repo]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestClient(t *testing.T, fakeRegistry *fakeRegistry) *Client {
+	server := httptest.NewTLSServer(fakeRegistry)
+	t.Cleanup(server.Close)
+	return NewClient(strings.TrimPrefix(server.URL, "https://"), staticCredential{}, WithHTTPClient(server.Client()))
+}
+
+func TestLoginServer(t *testing.T) {
+	assert.Equal(t, "radixdev.azurecr.io", LoginServer("radixdev"))
+	assert.Equal(t, "radixdev.azurecr.io", LoginServer("RadixDev.azurecr.io"))
+}
+
+func TestListRepositoriesFollowsLinks(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	fakeRegistry.repos = []string{"a", "b", "c", "d", "e"}
+	client := newTestClient(t, fakeRegistry)
+
+	repos, err := registry.Collect(client.ListRepositories(context.Background()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, repos)
+	assert.Equal(t, 1, fakeRegistry.exchanges)
+	assert.Equal(t, []string{catalogScope}, fakeRegistry.scopes)
+}
+
+func TestListManifestsPagedInTimeOrder(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	older := time.Date(2019, 10, 25, 10, 0, 0, 0, time.UTC)
+	fakeRegistry.manifests["app"] = []manifest.Data{
+		{Digest: "sha256:2", Tags: []string{"development-2"}, LastUpdateTime: older.Add(time.Hour)},
+		{Digest: "sha256:1", Tags: []string{"development-1"}, LastUpdateTime: older},
+		{Digest: "sha256:3", Tags: []string{"development-3"}, LastUpdateTime: older.Add(2 * time.Hour)},
+	}
+	client := newTestClient(t, fakeRegistry)
+
+	manifests, err := registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 3)
+	assert.Equal(t, "sha256:1", manifests[0].Digest)
+	assert.Equal(t, "sha256:2", manifests[1].Digest)
+	assert.Equal(t, "sha256:3", manifests[2].Digest)
+	assert.Equal(t, []string{"repository:app:metadata_read"}, fakeRegistry.scopes)
+
+	_, err = registry.Collect(client.ListManifests(context.Background(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestListManifestsStopsFetchingWhenBreaking(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	fakeRegistry.manifests["app"] = []manifest.Data{{Digest: "sha256:1"}, {Digest: "sha256:2"}, {Digest: "sha256:3"}}
+	client := newTestClient(t, fakeRegistry)
+
+	for m, err := range client.ListManifests(context.Background(), "app") {
+		require.NoError(t, err)
+		assert.Equal(t, "sha256:1", m.Digest)
+		break
+	}
+}
+
+func TestDeleteManifest(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	client := newTestClient(t, fakeRegistry)
+
+	err := client.DeleteManifest(context.Background(), "team/app", manifest.Data{Digest: "sha256:1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/app/manifests/sha256:1"}, fakeRegistry.deleted)
+	assert.Equal(t, []string{"repository:team/app:delete"}, fakeRegistry.scopes)
+}
+
+func TestUntag(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	client := newTestClient(t, fakeRegistry)
+
+	err := client.Untag(context.Background(), "app", "development-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app/_tags/development-1"}, fakeRegistry.deleted)
+}
+
+func TestDeleteRepository(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	client := newTestClient(t, fakeRegistry)
+
+	err := client.DeleteRepository(context.Background(), "team/app")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/app"}, fakeRegistry.deleted)
+	assert.Equal(t, []string{"repository:team/app:delete"}, fakeRegistry.scopes)
+}
+
+func TestAccessTokensAreCachedPerScope(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	fakeRegistry.manifests["app"] = []manifest.Data{}
+	client := newTestClient(t, fakeRegistry)
+
+	for i := 0; i < 3; i++ {
+		_, err := registry.Collect(client.ListManifests(context.Background(), "app"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, fakeRegistry.exchanges)
+	assert.Len(t, fakeRegistry.scopes, 1)
+}
+
+func TestConcurrentAccessTokensShareExchanges(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	client := newTestClient(t, fakeRegistry)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			token, err := client.accessToken(context.Background(), repositoryScope([]string{"a", "b"}[i%2], "pull"))
+			assert.NoError(t, err)
+			assert.Equal(t, "acr-access-token", token)
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, fakeRegistry.exchanges, "refresh token should be exchanged once")
+	assert.ElementsMatch(t, []string{"repository:a:pull", "repository:b:pull"}, fakeRegistry.scopes)
+}
+
+func TestGetRepositoryReadsLockAttributes(t *testing.T) {
+	deleteEnabled := false
+	fakeRegistry := newFakeRegistry()
+	fakeRegistry.manifests["locked"] = []manifest.Data{}
+	fakeRegistry.attributes["locked"] = manifest.ChangeableAttributes{DeleteEnabled: &deleteEnabled}
+	fakeRegistry.manifests["open"] = []manifest.Data{{Digest: "sha256:1"}}
+	client := newTestClient(t, fakeRegistry)
+
+	locked, err := client.GetRepository(context.Background(), "locked")
+	require.NoError(t, err)
+	assert.True(t, locked.ChangeableAttributes.IsLocked())
+
+	open, err := client.GetRepository(context.Background(), "open")
+	require.NoError(t, err)
+	assert.False(t, open.ChangeableAttributes.IsLocked())
+	assert.Equal(t, "2019-10-30T07:38:55Z", open.LastUpdateTime.Format(time.RFC3339))
+	assert.Equal(t, 1, open.ManifestCount)
+
+	_, err = client.GetRepository(context.Background(), "missing")
+	assert.ErrorIs(t, err, registry.ErrNotFound)
+}
+
+func TestListManifestsReadsLockAttributes(t *testing.T) {
+	deleteEnabled := false
+	fakeRegistry := newFakeRegistry()
+	fakeRegistry.manifests["app"] = []manifest.Data{
+		{Digest: "sha256:a", ChangeableAttributes: manifest.ChangeableAttributes{DeleteEnabled: &deleteEnabled}},
+	}
+	client := newTestClient(t, fakeRegistry)
+
+	manifests, err := registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+	assert.True(t, manifests[0].IsLocked())
+}
+
+func TestListIndexChildren(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	fakeRegistry.indexes["/v2/app/manifests/sha256:index"] = []string{"sha256:amd64", "sha256:arm64"}
+	client := newTestClient(t, fakeRegistry)
+
+	children, err := client.ListIndexChildren(context.Background(), "app", "sha256:index")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sha256:amd64", "sha256:arm64"}, children)
+
+	_, err = client.ListIndexChildren(context.Background(), "app", "sha256:missing")
+	assert.ErrorIs(t, err, registry.ErrNotFound)
+}
+
+func TestListReferrers(t *testing.T) {
+	fakeRegistry := newFakeRegistry()
+	fakeRegistry.indexes["/v2/app/referrers/sha256:subject"] = []string{"sha256:signature", "sha256:sbom"}
+	client := newTestClient(t, fakeRegistry)
+
+	referrers, err := client.ListReferrers(context.Background(), "app", "sha256:subject")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sha256:signature", "sha256:sbom"}, referrers)
+}
@@ -0,0 +1,213 @@
+package acr
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/aad"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+)
+
+const (
+	// ACR refresh tokens are valid for three hours and access tokens for 75 minutes.
+	// The fallbacks are only used if the expiry cannot be read from the token itself.
+	defaultRefreshTokenLifetime = 3 * time.Hour
+	defaultAccessTokenLifetime  = 75 * time.Minute
+	tokenExpiryMargin           = 5 * time.Minute
+
+	catalogScope = "registry:catalog:*"
+
+	// refreshTokenKey Key of the refresh token exchange among the access token exchanges, keyed by scope
+	refreshTokenKey = "refresh"
+)
+
+// TokenCredential Provides AAD access tokens accepted by the ACR token exchange
+type TokenCredential interface {
+	GetToken(ctx context.Context) (aad.Token, error)
+}
+
+type cachedToken struct {
+	value     string
+	expiresOn time.Time
+}
+
+func (t cachedToken) valid() bool {
+	return len(t.value) > 0 && time.Now().Add(tokenExpiryMargin).Before(t.expiresOn)
+}
+
+func repositoryScope(repository string, actions ...string) string {
+	return fmt.Sprintf("repository:%s:%s", repository, strings.Join(actions, ","))
+}
+
+// accessToken Returns a cached ACR access token for the scope, exchanging a new one when needed.
+// The lock is only held to read and write the cache, so exchanges for different scopes run concurrently,
+// while concurrent exchanges for the same scope are made once
+func (c *Client) accessToken(ctx context.Context, scope string) (string, error) {
+	if token, ok := c.cachedAccessToken(scope); ok {
+		return token, nil
+	}
+
+	value, err, _ := c.exchanges.Do("scope "+scope, func() (interface{}, error) {
+		// An exchange which ended after the cache was read may have stored a token already
+		if token, ok := c.cachedAccessToken(scope); ok {
+			return token, nil
+		}
+
+		refreshToken, err := c.validRefreshToken(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		token, err := c.exchangeAccessToken(ctx, refreshToken, scope)
+		if err != nil {
+			return "", err
+		}
+
+		c.mu.Lock()
+		c.accessTokens[scope] = token
+		c.mu.Unlock()
+		return token.value, nil
+	})
+	return value.(string), err
+}
+
+// validRefreshToken Returns the cached ACR refresh token, exchanging a new one when needed.
+// Concurrent exchanges are made once
+func (c *Client) validRefreshToken(ctx context.Context) (string, error) {
+	if token, ok := c.cachedRefreshToken(); ok {
+		return token, nil
+	}
+
+	value, err, _ := c.exchanges.Do(refreshTokenKey, func() (interface{}, error) {
+		if token, ok := c.cachedRefreshToken(); ok {
+			return token, nil
+		}
+
+		token, err := c.exchangeRefreshToken(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		c.mu.Lock()
+		c.refreshToken = token
+		c.mu.Unlock()
+		return token.value, nil
+	})
+	return value.(string), err
+}
+
+func (c *Client) cachedAccessToken(scope string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	token, ok := c.accessTokens[scope]
+	return token.value, ok && token.valid()
+}
+
+func (c *Client) cachedRefreshToken() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.refreshToken.value, c.refreshToken.valid()
+}
+
+// exchangeRefreshToken Exchanges an AAD access token for an ACR refresh token
+func (c *Client) exchangeRefreshToken(ctx context.Context) (cachedToken, error) {
+	aadToken, err := c.credential.GetToken(ctx)
+	if err != nil {
+		return cachedToken{}, registry.AuthError(fmt.Errorf("get AAD token for %s failed: %w", c.loginServer, err))
+	}
+
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {c.loginServer},
+		"access_token": {aadToken.AccessToken},
+	}
+	if len(c.tenantID) > 0 {
+		form.Set("tenant", c.tenantID)
+	}
+
+	var response struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.postForm(ctx, "/oauth2/exchange", form, &response); err != nil {
+		return cachedToken{}, fmt.Errorf("exchange refresh token for %s failed: %w", c.loginServer, err)
+	}
+
+	return cachedToken{
+		value:     response.RefreshToken,
+		expiresOn: tokenExpiry(response.RefreshToken, defaultRefreshTokenLifetime),
+	}, nil
+}
+
+// exchangeAccessToken Exchanges the ACR refresh token for an access token limited to scope
+func (c *Client) exchangeAccessToken(ctx context.Context, refreshToken, scope string) (cachedToken, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"service":       {c.loginServer},
+		"scope":         {scope},
+		"refresh_token": {refreshToken},
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := c.postForm(ctx, "/oauth2/token", form, &response); err != nil {
+		return cachedToken{}, fmt.Errorf("exchange access token for scope %s failed: %w", scope, err)
+	}
+
+	return cachedToken{
+		value:     response.AccessToken,
+		expiresOn: tokenExpiry(response.AccessToken, defaultAccessTokenLifetime),
+	}, nil
+}
+
+func (c *Client) postForm(ctx context.Context, path string, form url.Values, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := registry.Do(c.httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return registry.ResponseError(resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// tokenExpiry Reads the exp claim of a JWT without verifying it, falling back to now + lifetime
+func tokenExpiry(token string, lifetime time.Duration) time.Time {
+	fallback := time.Now().Add(lifetime)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fallback
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fallback
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return fallback
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Entry State of a repository when it was last evaluated without any manifest being deleted
+type Entry struct {
+	LastUpdateTime time.Time `json:"lastUpdateTime"`
+	ManifestCount  int       `json:"manifestCount"`
+	// Fingerprint Identifies the settings and the images in use the repository was evaluated with
+	Fingerprint string    `json:"fingerprint"`
+	Evaluated   time.Time `json:"evaluated"`
+}
+
+// Store Persists the entries between runs
+type Store interface {
+	// Load Returns the saved entries, or no entries if nothing has been saved
+	Load(ctx context.Context) (map[string]Entry, error)
+	// Save Replaces the saved entries
+	Save(ctx context.Context, entries map[string]Entry) error
+}
+
+// Cache Entries of the previous run, and the entries still valid in the current run
+//
+// Only entries looked up or recorded during the current run are saved, so repositories
+// which are deleted, or not reached because the run was aborted, are dropped
+type Cache struct {
+	store  Store
+	maxAge time.Duration
+
+	mu       sync.Mutex
+	previous map[string]Entry
+	current  map[string]Entry
+}
+
+// New Constructor for Cache. Entries older than maxAge are never unchanged, forcing a full evaluation
+func New(store Store, maxAge time.Duration) *Cache {
+	return &Cache{
+		store:    store,
+		maxAge:   maxAge,
+		previous: make(map[string]Entry),
+		current:  make(map[string]Entry),
+	}
+}
+
+// Load Reads the entries of the previous run from the store. If they cannot be read, the run starts without entries
+func (c *Cache) Load(ctx context.Context) error {
+	entries, err := c.store.Load(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.previous = entries
+	if err != nil {
+		c.previous = make(map[string]Entry)
+	}
+	c.current = make(map[string]Entry)
+	return err
+}
+
+// Unchanged Returns true if the repository had the same state when last evaluated, and keeps the entry for the next run
+func (c *Cache) Unchanged(key string, entry Entry, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous, ok := c.previous[key]
+	if !ok || !previous.LastUpdateTime.Equal(entry.LastUpdateTime) || previous.ManifestCount != entry.ManifestCount ||
+		previous.Fingerprint != entry.Fingerprint || now.Sub(previous.Evaluated) >= c.maxAge {
+		return false
+	}
+
+	c.current[key] = previous
+	return true
+}
+
+// Record Stores the state of an evaluated repository
+func (c *Cache) Record(key string, entry Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.current[key] = entry
+}
+
+// Save Writes the entries of the current run to the store
+func (c *Cache) Save(ctx context.Context) error {
+	c.mu.Lock()
+	entries := make(map[string]Entry, len(c.current))
+	for key, entry := range c.current {
+		entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	return c.store.Save(ctx, entries)
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore struct {
+	entries map[string]Entry
+}
+
+func (s *memoryStore) Load(context.Context) (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+	for key, entry := range s.entries {
+		entries[key] = entry
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) Save(_ context.Context, entries map[string]Entry) error {
+	s.entries = entries
+	return nil
+}
+
+func TestCacheKeepsOnlyEntriesOfCurrentRun(t *testing.T) {
+	now := time.Now()
+	updated := now.Add(-time.Hour)
+	store := &memoryStore{}
+	cache := New(store, 24*time.Hour)
+
+	require.NoError(t, cache.Load(context.Background()))
+	entry := Entry{LastUpdateTime: updated, ManifestCount: 2, Fingerprint: "f", Evaluated: now}
+	assert.False(t, cache.Unchanged("radixdev/app", entry, now))
+	cache.Record("radixdev/app", entry)
+	cache.Record("radixdev/deleted", entry)
+	require.NoError(t, cache.Save(context.Background()))
+
+	require.NoError(t, cache.Load(context.Background()))
+	later := now.Add(time.Hour)
+	assert.True(t, cache.Unchanged("radixdev/app", Entry{LastUpdateTime: updated, ManifestCount: 2, Fingerprint: "f", Evaluated: later}, later))
+	require.NoError(t, cache.Save(context.Background()))
+	assert.Equal(t, map[string]Entry{"radixdev/app": entry}, store.entries, "unchanged entry should be kept with its evaluation time")
+}
+
+func TestCacheUnchanged(t *testing.T) {
+	now := time.Now()
+	updated := now.Add(-time.Hour)
+	entry := Entry{LastUpdateTime: updated, ManifestCount: 2, Fingerprint: "f", Evaluated: now.Add(-time.Hour)}
+	store := &memoryStore{entries: map[string]Entry{"radixdev/app": entry}}
+
+	tests := []struct {
+		name      string
+		entry     Entry
+		now       time.Time
+		unchanged bool
+	}{
+		{name: "same state", entry: entry, now: now, unchanged: true},
+		{name: "updated", entry: Entry{LastUpdateTime: now, ManifestCount: 2, Fingerprint: "f"}, now: now},
+		{name: "manifest count changed", entry: Entry{LastUpdateTime: updated, ManifestCount: 1, Fingerprint: "f"}, now: now},
+		{name: "images in use changed", entry: Entry{LastUpdateTime: updated, ManifestCount: 2, Fingerprint: "g"}, now: now},
+		{name: "evaluated too long ago", entry: entry, now: now.Add(24 * time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := New(store, 24*time.Hour)
+			require.NoError(t, cache.Load(context.Background()))
+			assert.Equal(t, test.unchanged, cache.Unchanged("radixdev/app", test.entry, test.now))
+			assert.False(t, cache.Unchanged("radixdev/other", test.entry, test.now))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMapKey Key of the entries in the ConfigMap
+const ConfigMapKey = "cache.json"
+
+// FileStore Stores entries as JSON in a local file
+type FileStore struct {
+	Path string
+}
+
+// Load Reads entries from the file, or returns no entries if it does not exist
+func (s FileStore) Load(_ context.Context) (map[string]Entry, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]Entry), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read cache file %s failed: %w", s.Path, err)
+	}
+
+	return decode(data, s.Path)
+}
+
+// Save Writes entries to a temporary file, which replaces the file
+func (s FileStore) Save(_ context.Context, entries map[string]Entry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("write cache file %s failed: %w", s.Path, err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return fmt.Errorf("write cache file %s failed: %w", s.Path, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("write cache file %s failed: %w", s.Path, err)
+	}
+	if err := os.Rename(temp.Name(), s.Path); err != nil {
+		return fmt.Errorf("write cache file %s failed: %w", s.Path, err)
+	}
+	return nil
+}
+
+// ConfigMapStore Stores entries as JSON in a ConfigMap
+type ConfigMapStore struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// Load Reads entries from the ConfigMap, or returns no entries if it does not exist
+func (s ConfigMapStore) Load(ctx context.Context) (map[string]Entry, error) {
+	configMap, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if kubeerrors.IsNotFound(err) {
+		return make(map[string]Entry), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get cache configmap %s/%s failed: %w", s.Namespace, s.Name, err)
+	}
+
+	data, ok := configMap.Data[ConfigMapKey]
+	if !ok {
+		return make(map[string]Entry), nil
+	}
+	return decode([]byte(data), s.Namespace+"/"+s.Name)
+}
+
+// Save Updates the ConfigMap, creating it if it does not exist
+func (s ConfigMapStore) Save(ctx context.Context, entries map[string]Entry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+	configMap, err := configMaps.Get(ctx, s.Name, metav1.GetOptions{})
+	if kubeerrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: s.Namespace},
+			Data:       map[string]string{ConfigMapKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("create cache configmap %s/%s failed: %w", s.Namespace, s.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get cache configmap %s/%s failed: %w", s.Namespace, s.Name, err)
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[ConfigMapKey] = string(data)
+	if _, err := configMaps.Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("update cache configmap %s/%s failed: %w", s.Namespace, s.Name, err)
+	}
+	return nil
+}
+
+// decode Parses entries stored as JSON
+func decode(data []byte, source string) (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse cache %s failed: %w", source, err)
+	}
+	return entries, nil
+}
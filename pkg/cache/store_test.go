@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func testEntries() map[string]Entry {
+	updated, _ := time.Parse(time.RFC3339, "2020-01-01T12:00:00Z")
+	return map[string]Entry{"radixdev/app": {LastUpdateTime: updated, ManifestCount: 2, Fingerprint: "f", Evaluated: updated.Add(time.Hour)}}
+}
+
+func TestFileStore(t *testing.T) {
+	store := FileStore{Path: filepath.Join(t.TempDir(), "cache.json")}
+
+	entries, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, store.Save(context.Background(), testEntries()))
+	entries, err = store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testEntries(), entries)
+
+	require.NoError(t, os.WriteFile(store.Path, []byte("{"), 0600))
+	_, err = store.Load(context.Background())
+	assert.Error(t, err)
+}
+
+func TestConfigMapStore(t *testing.T) {
+	client := kubefake.NewClientset()
+	store := ConfigMapStore{Client: client, Namespace: "radix-acr-cleanup", Name: "radix-acr-cleanup-cache"}
+
+	entries, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, store.Save(context.Background(), testEntries()), "missing configmap should be created")
+	require.NoError(t, store.Save(context.Background(), testEntries()), "existing configmap should be updated")
+	entries, err = store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testEntries(), entries)
+
+	configMap, err := client.CoreV1().ConfigMaps("radix-acr-cleanup").Get(context.Background(), "radix-acr-cleanup-cache", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, configMap.Data, ConfigMapKey)
+}
@@ -1,31 +1,144 @@
 package image
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 // Data Structure to hold image information
 type Data struct {
-	Registry   string
+	// Registry Domain of the registry, with port if given. docker.io for references without domain
+	Registry string
+	// Repository Path of the repository within the registry
 	Repository string
-	Tag        string
+	// Tag Tag of the image. latest for references with neither tag nor digest
+	Tag string
+	// Digest Digest the image is pinned to, if any
+	Digest string
 }
 
-// Parse will deconstruct container image
+// DefaultRegistry Registry of references without domain
+const DefaultRegistry = "docker.io"
+
+const (
+	defaultTag            = "latest"
+	officialRepositoryDir = "library"
+	maxNameLength         = 255
+)
+
+var (
+	// ErrInvalidReference The reference does not follow the reference grammar
+	ErrInvalidReference = errors.New("invalid reference format")
+	// ErrNameTooLong The normalized name of the reference is longer than 255 characters
+	ErrNameTooLong = errors.New("repository name must not be more than 255 characters")
+	// ErrNameNotLowercase The repository of the reference has uppercase characters
+	ErrNameNotLowercase = errors.New("repository name must be lowercase")
+	// ErrInvalidDigest The digest of the reference is malformed
+	ErrInvalidDigest = errors.New("invalid digest format")
+)
+
+// Patterns of the distribution reference grammar
+var (
+	domainPattern        = regexp.MustCompile(`^(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*|\[[a-fA-F0-9:]+\])(?::[0-9]+)?$`)
+	pathComponentPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	tagPattern           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestPattern        = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+	// digestLengths Hex length of the encoded part of registered algorithms
+	digestLengths = map[string]int{"sha256": 64, "sha512": 128}
+)
+
+// Parse will deconstruct container image, returning nil for images not following the reference grammar
 func Parse(image string) *Data {
-	imageRepository := strings.Split(image, "/")
-	if len(imageRepository) == 1 {
+	data, err := ParseReference(strings.TrimSpace(image))
+	if err != nil {
 		return nil
 	}
+	return &data
+}
+
+// ParseReference Parses a reference of the form [domain[:port]/]path[:tag][@digest], such as
+// myacr.azurecr.io/team/app:tag, localhost:5000/app@sha256:... or nginx. References without domain
+// are normalized to docker.io, with library/ added to single component paths
+func ParseReference(reference string) (Data, error) {
+	var data Data
+	name := reference
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, data.Digest = name[:i], name[i+1:]
+		if err := validateDigest(data.Digest); err != nil {
+			return Data{}, err
+		}
+	}
+
+	if i := strings.LastIndex(name, ":"); i >= 0 && i > strings.LastIndex(name, "/") {
+		name, data.Tag = name[:i], name[i+1:]
+		if !tagPattern.MatchString(data.Tag) {
+			return Data{}, fmt.Errorf("%w: tag %q", ErrInvalidReference, data.Tag)
+		}
+	}
+	if len(name) == 0 {
+		return Data{}, fmt.Errorf("%w: %q has no repository", ErrInvalidReference, reference)
+	}
 
-	repository := imageRepository[0]
-	imageTag := strings.Split(imageRepository[1], ":")
+	domain, path, hasDomain := strings.Cut(name, "/")
+	if !hasDomain || !isDomain(domain) {
+		domain, path = DefaultRegistry, name
+		if !strings.Contains(path, "/") {
+			path = officialRepositoryDir + "/" + path
+		}
+	} else if !domainPattern.MatchString(domain) {
+		return Data{}, fmt.Errorf("%w: domain %q", ErrInvalidReference, domain)
+	}
 
-	if len(imageTag) == 1 {
-		return nil
+	for _, component := range strings.Split(path, "/") {
+		if !pathComponentPattern.MatchString(component) {
+			if pathComponentPattern.MatchString(strings.ToLower(component)) {
+				return Data{}, ErrNameNotLowercase
+			}
+			return Data{}, fmt.Errorf("%w: path component %q", ErrInvalidReference, component)
+		}
 	}
+	if len(domain)+1+len(path) > maxNameLength {
+		return Data{}, ErrNameTooLong
+	}
+	data.Registry, data.Repository = domain, path
+
+	if len(data.Tag) == 0 && len(data.Digest) == 0 {
+		data.Tag = defaultTag
+	}
+	return data, nil
+}
+
+// isDomain Indicates that the first component of a name is a domain rather than part of the path,
+// which is the case if it has a dot or a port, is localhost, or has uppercase characters
+func isDomain(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost" || strings.ToLower(component) != component
+}
 
-	return &Data{
-		strings.TrimSpace(repository),
-		strings.TrimSpace(imageTag[0]),
-		strings.TrimSpace(imageTag[1]),
+// validateDigest Checks the format of a digest, and the length of digests of registered algorithms
+func validateDigest(digest string) error {
+	if !digestPattern.MatchString(digest) {
+		return fmt.Errorf("%w: %q", ErrInvalidDigest, digest)
+	}
+
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	if length, ok := digestLengths[algorithm]; ok {
+		if len(encoded) != length || strings.Trim(encoded, "0123456789abcdef") != "" {
+			return fmt.Errorf("%w: %q", ErrInvalidDigest, digest)
+		}
+	}
+	return nil
+}
+
+// String Formats the image as a reference
+func (image Data) String() string {
+	reference := image.Registry + "/" + image.Repository
+	if len(image.Tag) > 0 {
+		reference += ":" + image.Tag
+	}
+	if len(image.Digest) > 0 {
+		reference += "@" + image.Digest
 	}
+	return reference
 }
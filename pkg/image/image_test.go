@@ -1,11 +1,14 @@
 package image
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+const digest = "sha256:7f8343822a17acc74c88c96badc2a1a981ad9fc749b4c5194816c0ef01fc9457"
+
 func TestParseRelevant(t *testing.T) {
 	image := Parse("repo.azurecr.io/some-repo:some-tag")
 	assert.Equal(t, "repo.azurecr.io", image.Registry)
@@ -14,9 +17,85 @@ func TestParseRelevant(t *testing.T) {
 }
 
 func TestParseIrrelevant(t *testing.T) {
-	image := Parse("repo.azurecr.io/some-repo")
+	image := Parse("repo.azurecr.io/Some-Repo:some-tag")
 	assert.Nil(t, image)
 
-	image = Parse("some-repo:some-tag")
+	image = Parse("some-repo:some:tag")
 	assert.Nil(t, image)
 }
+
+func TestParseReference(t *testing.T) {
+	for reference, expected := range map[string]Data{
+		"myacr.azurecr.io/team/app:tag":                  {Registry: "myacr.azurecr.io", Repository: "team/app", Tag: "tag"},
+		"host:5000/app:tag":                              {Registry: "host:5000", Repository: "app", Tag: "tag"},
+		"host:5000/app":                                  {Registry: "host:5000", Repository: "app", Tag: "latest"},
+		"localhost/app":                                  {Registry: "localhost", Repository: "app", Tag: "latest"},
+		"[::1]:5000/team/app:1.0":                        {Registry: "[::1]:5000", Repository: "team/app", Tag: "1.0"},
+		"myacr.azurecr.io/app@" + digest:                 {Registry: "myacr.azurecr.io", Repository: "app", Digest: digest},
+		"myacr.azurecr.io/app:v1.2.3@" + digest:          {Registry: "myacr.azurecr.io", Repository: "app", Tag: "v1.2.3", Digest: digest},
+		"app@" + digest:                                  {Registry: "docker.io", Repository: "library/app", Digest: digest},
+		"nginx:1.25":                                     {Registry: "docker.io", Repository: "library/nginx", Tag: "1.25"},
+		"team/app":                                       {Registry: "docker.io", Repository: "team/app", Tag: "latest"},
+		"docker.io/library/nginx:1.25":                   {Registry: "docker.io", Repository: "library/nginx", Tag: "1.25"},
+		"myacr.azurecr.io/a.b_c__d--e/f:Tag_1.0-rc":      {Registry: "myacr.azurecr.io", Repository: "a.b_c__d--e/f", Tag: "Tag_1.0-rc"},
+		"myacr.azurecr.io/app@sha384+b64:c29tZS1kYXRh==": {Registry: "myacr.azurecr.io", Repository: "app", Digest: "sha384+b64:c29tZS1kYXRh=="},
+	} {
+		data, err := ParseReference(reference)
+		assert.NoError(t, err, reference)
+		assert.Equal(t, expected, data, reference)
+	}
+}
+
+func TestParseReferenceRejectsInvalidReferences(t *testing.T) {
+	for reference, expected := range map[string]error{
+		"":                          ErrInvalidReference,
+		":tag":                      ErrInvalidReference,
+		"myacr.azurecr.io/App:tag":  ErrNameNotLowercase,
+		"Nginx":                     ErrNameNotLowercase,
+		"myacr.azurecr.io/app:-tag": ErrInvalidReference,
+		"myacr.azurecr.io/app:" + strings.Repeat("t", 129): ErrInvalidReference,
+		"myacr.azurecr.io//app":                            ErrInvalidReference,
+		"myacr.azurecr.io/app/":                            ErrInvalidReference,
+		"myacr.azurecr.io/-app":                            ErrInvalidReference,
+		"-host.io/app":                                     ErrInvalidReference,
+		"host:port/app":                                    ErrInvalidReference,
+		"myacr.azurecr.io/app@sha256:abc":                  ErrInvalidDigest,
+		"myacr.azurecr.io/app@" + strings.ToUpper(digest):  ErrInvalidDigest,
+		"myacr.azurecr.io/app@md5":                         ErrInvalidDigest,
+		"myacr.azurecr.io/app@" + digest + "@" + digest:    ErrInvalidDigest,
+		"myacr.azurecr.io/" + strings.Repeat("a", 256):     ErrNameTooLong,
+	} {
+		_, err := ParseReference(reference)
+		assert.ErrorIs(t, err, expected, reference)
+	}
+}
+
+func FuzzParseReference(f *testing.F) {
+	for _, reference := range []string{
+		"myacr.azurecr.io/team/app:tag",
+		"host:5000/app:tag@" + digest,
+		"[::1]:5000/app",
+		"app@" + digest,
+		"nginx",
+		"a/b/c:d@e:f",
+		"::@@//",
+	} {
+		f.Add(reference)
+	}
+
+	f.Fuzz(func(t *testing.T, reference string) {
+		data, err := ParseReference(reference)
+		if err != nil {
+			return
+		}
+
+		assert.NotEmpty(t, data.Registry)
+		assert.NotEmpty(t, data.Repository)
+		assert.Equal(t, strings.ToLower(data.Repository), data.Repository)
+		assert.True(t, len(data.Tag) > 0 || len(data.Digest) > 0)
+
+		reparsed, err := ParseReference(data.String())
+		assert.NoError(t, err, data.String())
+		assert.Equal(t, data, reparsed)
+	})
+}
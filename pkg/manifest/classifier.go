@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Category What a tag tells about the manifest it is on
+type Category string
+
+const (
+	// CategoryNone Tags telling nothing about the manifest, which do not affect retention
+	CategoryNone Category = ""
+	// CategoryClusterType Tags marking the image as built for a cluster type
+	CategoryClusterType Category = "cluster-type"
+)
+
+// Classification The category of a tag, and the cluster type of cluster type tags
+type Classification struct {
+	Category    Category
+	ClusterType string
+}
+
+// Classifier Classifies tags by the first matching rule. Tags matching no rule are classified by the tag prefixes
+// of the cluster types. A nil Classifier uses DefaultClusterTypes and no rules
+type Classifier struct {
+	clusterTypes ClusterTypes
+	rules        []Rule
+}
+
+var defaultClassifier = &Classifier{clusterTypes: DefaultClusterTypes}
+
+// NewClassifier Validates the rules, and returns a classifier applying them in order before the tag prefixes of the cluster types
+func NewClassifier(clusterTypes ClusterTypes, rules []Rule) (*Classifier, error) {
+	classifier := &Classifier{clusterTypes: clusterTypes.orDefault(), rules: make([]Rule, 0, len(rules))}
+	for i, rule := range rules {
+		if err := rule.compile(classifier.clusterTypes); err != nil {
+			return nil, fmt.Errorf("tag rule %d %w", i+1, err)
+		}
+		classifier.rules = append(classifier.rules, rule)
+	}
+	return classifier, nil
+}
+
+// Classify Returns the category of a tag
+func (classifier *Classifier) Classify(tag string) Classification {
+	classifier = classifier.orDefault()
+	for _, rule := range classifier.rules {
+		if rule.matches(tag) {
+			return Classification{Category: rule.Category, ClusterType: rule.ClusterType}
+		}
+	}
+	for _, clusterType := range classifier.clusterTypes {
+		if clusterType.IsTag(tag) {
+			return Classification{Category: CategoryClusterType, ClusterType: clusterType.Name}
+		}
+	}
+	return Classification{Category: CategoryNone}
+}
+
+// ClusterTypes Returns the known cluster types
+func (classifier *Classifier) ClusterTypes() ClusterTypes {
+	return classifier.orDefault().clusterTypes
+}
+
+// String Describes the cluster types and rules
+func (classifier *Classifier) String() string {
+	classifier = classifier.orDefault()
+	descriptions := make([]string, 0, len(classifier.rules))
+	for _, rule := range classifier.rules {
+		descriptions = append(descriptions, rule.String())
+	}
+	return fmt.Sprintf("cluster types %s, rules [%s]", classifier.clusterTypes, strings.Join(descriptions, "; "))
+}
+
+func (classifier *Classifier) orDefault() *Classifier {
+	if classifier == nil {
+		return defaultClassifier
+	}
+	return classifier
+}
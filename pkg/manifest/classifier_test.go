@@ -0,0 +1,14 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilClassifierUsesDefaultClusterTypes(t *testing.T) {
+	var classifier *Classifier
+	assert.Equal(t, Classification{Category: CategoryClusterType, ClusterType: "playground"}, classifier.Classify("playground-abc"))
+	assert.Equal(t, Classification{Category: CategoryNone}, classifier.Classify("latest"))
+	assert.Equal(t, DefaultClusterTypes, classifier.ClusterTypes())
+}
@@ -0,0 +1,124 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ClusterType A type of cluster, and the tag prefixes of the images built for it
+type ClusterType struct {
+	Name        string
+	TagPrefixes []string
+}
+
+// ClusterTypes The known cluster types. Manifests without tags for any of them are untagged
+type ClusterTypes []ClusterType
+
+// DefaultClusterTypes Cluster types known when none are configured
+var DefaultClusterTypes = ClusterTypes{
+	{Name: "development", TagPrefixes: []string{"development-"}},
+	{Name: "production", TagPrefixes: []string{"production-"}},
+	{Name: "playground", TagPrefixes: []string{"playground-"}},
+}
+
+var (
+	clusterTypeNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	tagPrefixPattern       = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+// ParseClusterTypes Parses cluster types given as name, tagged with the prefix name-,
+// or as name=prefix|prefix with explicit tag prefixes
+func ParseClusterTypes(specs []string) (ClusterTypes, error) {
+	clusterTypes := make(ClusterTypes, 0, len(specs))
+	for _, spec := range specs {
+		name, prefixes, hasPrefixes := strings.Cut(strings.TrimSpace(spec), "=")
+		clusterType := ClusterType{Name: strings.TrimSpace(name)}
+		if hasPrefixes {
+			for _, prefix := range strings.Split(prefixes, "|") {
+				clusterType.TagPrefixes = append(clusterType.TagPrefixes, strings.TrimSpace(prefix))
+			}
+		} else {
+			clusterType.TagPrefixes = []string{clusterType.Name + "-"}
+		}
+		clusterTypes = append(clusterTypes, clusterType)
+	}
+
+	if err := clusterTypes.Validate(); err != nil {
+		return nil, err
+	}
+	return clusterTypes, nil
+}
+
+// Validate Checks that names are unique, and that no tag can match the prefixes of more than one cluster type
+func (clusterTypes ClusterTypes) Validate() error {
+	if len(clusterTypes) == 0 {
+		return fmt.Errorf("no cluster types given")
+	}
+
+	seen := make(map[string]bool)
+	for i, clusterType := range clusterTypes {
+		if !clusterTypeNamePattern.MatchString(clusterType.Name) {
+			return fmt.Errorf("cluster type name %q is invalid", clusterType.Name)
+		}
+		if seen[clusterType.Name] {
+			return fmt.Errorf("cluster type %s is given more than once", clusterType.Name)
+		}
+		seen[clusterType.Name] = true
+
+		if len(clusterType.TagPrefixes) == 0 {
+			return fmt.Errorf("cluster type %s has no tag prefixes", clusterType.Name)
+		}
+		for _, prefix := range clusterType.TagPrefixes {
+			if !tagPrefixPattern.MatchString(prefix) {
+				return fmt.Errorf("tag prefix %q of cluster type %s is invalid", prefix, clusterType.Name)
+			}
+			for _, other := range clusterTypes[:i] {
+				for _, otherPrefix := range other.TagPrefixes {
+					if strings.HasPrefix(prefix, otherPrefix) || strings.HasPrefix(otherPrefix, prefix) {
+						return fmt.Errorf("tag prefix %q of cluster type %s overlaps tag prefix %q of cluster type %s",
+							prefix, clusterType.Name, otherPrefix, other.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Contains Indicates that a cluster type is known
+func (clusterTypes ClusterTypes) Contains(name string) bool {
+	for _, clusterType := range clusterTypes.orDefault() {
+		if clusterType.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// String Formats cluster types the way ParseClusterTypes reads them
+func (clusterTypes ClusterTypes) String() string {
+	specs := make([]string, 0, len(clusterTypes))
+	for _, clusterType := range clusterTypes.orDefault() {
+		specs = append(specs, clusterType.Name+"="+strings.Join(clusterType.TagPrefixes, "|"))
+	}
+	return strings.Join(specs, ",")
+}
+
+// orDefault Returns DefaultClusterTypes for an empty set
+func (clusterTypes ClusterTypes) orDefault() ClusterTypes {
+	if len(clusterTypes) == 0 {
+		return DefaultClusterTypes
+	}
+	return clusterTypes
+}
+
+// IsTag Indicates that a tag marks an image built for the cluster type
+func (clusterType ClusterType) IsTag(tag string) bool {
+	for _, prefix := range clusterType.TagPrefixes {
+		if strings.HasPrefix(tag, prefix) {
+			return true
+		}
+	}
+	return false
+}
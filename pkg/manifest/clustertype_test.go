@@ -0,0 +1,56 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClusterTypes(t *testing.T) {
+	clusterTypes, err := ParseClusterTypes([]string{"development", "production=production-|prod-39-", " c2 ", "qa"})
+	assert.NoError(t, err)
+	assert.Equal(t, ClusterTypes{
+		{Name: "development", TagPrefixes: []string{"development-"}},
+		{Name: "production", TagPrefixes: []string{"production-", "prod-39-"}},
+		{Name: "c2", TagPrefixes: []string{"c2-"}},
+		{Name: "qa", TagPrefixes: []string{"qa-"}},
+	}, clusterTypes)
+	assert.Equal(t, "development=development-,production=production-|prod-39-,c2=c2-,qa=qa-", clusterTypes.String())
+
+	for _, specs := range [][]string{
+		{},
+		{""},
+		{"Development"},
+		{"development", "development"},
+		{"production="},
+		{"production=prod-|"},
+		{"production=prod 39-"},
+		{"production=prod-", "playground=prod-39-"},
+	} {
+		_, err := ParseClusterTypes(specs)
+		assert.Error(t, err, specs)
+	}
+}
+
+func TestClusterTypesAreUsedConsistently(t *testing.T) {
+	clusterTypes, err := ParseClusterTypes([]string{"development", "production=production-|prod-39-", "c2", "qa"})
+	assert.NoError(t, err)
+	classifier, err := NewClassifier(clusterTypes, nil)
+	assert.NoError(t, err)
+
+	c2 := Data{Tags: []string{"1.0", "c2-1"}}
+	assert.True(t, c2.IsNotTaggedForAnyClustertype(nil))
+	assert.False(t, c2.IsNotTaggedForAnyClustertype(classifier))
+	assert.True(t, c2.IsTaggedForCurrentClustertype(classifier, "c2"))
+	assert.True(t, c2.IsTaggedForOtherClustertype(classifier, "qa"))
+	assert.False(t, c2.IsTaggedForOtherClustertype(classifier, "c2"))
+
+	production := Data{Tags: []string{"prod-39-1", "production-1", "development-1"}}
+	assert.True(t, production.IsTaggedForCurrentClustertype(classifier, "production"))
+	assert.Equal(t, []string{"prod-39-1", "production-1"}, production.ClusterTypeTags(classifier, "production"))
+	assert.True(t, Data{Tags: []string{"prod-39-1"}}.IsNotTaggedForAnyClustertype(nil))
+
+	assert.True(t, clusterTypes.Contains("qa"))
+	assert.False(t, clusterTypes.Contains("playground"))
+	assert.True(t, ClusterTypes(nil).Contains("playground"))
+}
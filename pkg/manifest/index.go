@@ -0,0 +1,76 @@
+package manifest
+
+// Media types of image manifests and of indexes referencing one manifest per platform
+const (
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// IndexMediaTypes Media types of manifests referencing other manifests
+var IndexMediaTypes = []string{MediaTypeOCIIndex, MediaTypeDockerManifestList}
+
+// MediaTypes Media types of all supported manifests
+var MediaTypes = []string{MediaTypeOCIIndex, MediaTypeOCIManifest, MediaTypeDockerManifestList, MediaTypeDockerManifest}
+
+// IsIndex Indicates that the manifest is an image index or manifest list
+func (manifest Data) IsIndex() bool {
+	return manifest.MediaType == MediaTypeOCIIndex || manifest.MediaType == MediaTypeDockerManifestList
+}
+
+// Dependents Tracks manifests referenced by indexes in a repository, and referrers attached to a subject.
+// A dependent manifest must be kept as long as any manifest it depends on is kept
+type Dependents struct {
+	parents   map[string]map[string]bool
+	children  map[string][]string
+	referrers map[string]bool
+}
+
+// NewDependents Constructor for Dependents
+func NewDependents() *Dependents {
+	return &Dependents{
+		parents:   make(map[string]map[string]bool),
+		children:  make(map[string][]string),
+		referrers: make(map[string]bool),
+	}
+}
+
+// Add Records that parent references children
+func (d *Dependents) Add(parent string, children ...string) {
+	for _, child := range children {
+		if d.parents[child] == nil {
+			d.parents[child] = make(map[string]bool)
+		}
+		d.parents[child][parent] = false
+	}
+	d.children[parent] = append(d.children[parent], children...)
+}
+
+// IsDependent Indicates that digest is referenced by an index or attached to a subject
+func (d *Dependents) IsDependent(digest string) bool {
+	return len(d.parents[digest]) > 0
+}
+
+// IsReleased Indicates that digest is referenced by indexes which have all been deleted
+func (d *Dependents) IsReleased(digest string) bool {
+	parents := d.parents[digest]
+	for _, deleted := range parents {
+		if !deleted {
+			return false
+		}
+	}
+	return len(parents) > 0
+}
+
+// ParentDeleted Records that parent has been deleted, and returns the children released by it
+func (d *Dependents) ParentDeleted(parent string) []string {
+	released := make([]string, 0)
+	for _, child := range d.children[parent] {
+		d.parents[child][parent] = true
+		if d.IsReleased(child) {
+			released = append(released, child)
+		}
+	}
+	return released
+}
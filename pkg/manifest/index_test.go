@@ -0,0 +1,33 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsIndex(t *testing.T) {
+	assert.True(t, Data{MediaType: MediaTypeOCIIndex}.IsIndex())
+	assert.True(t, Data{MediaType: MediaTypeDockerManifestList}.IsIndex())
+	assert.False(t, Data{MediaType: MediaTypeOCIManifest}.IsIndex())
+	assert.False(t, Data{}.IsIndex())
+}
+
+func TestDependentsAreReleasedWhenAllParentsAreDeleted(t *testing.T) {
+	dependents := NewDependents()
+	dependents.Add("index-1", "amd64", "shared")
+	dependents.Add("index-2", "shared")
+
+	assert.True(t, dependents.IsDependent("shared"))
+	assert.False(t, dependents.IsDependent("index-1"))
+	assert.False(t, dependents.IsReleased("shared"))
+	assert.False(t, dependents.IsReleased("index-1"))
+
+	assert.Equal(t, []string{"amd64"}, dependents.ParentDeleted("index-1"))
+	assert.True(t, dependents.IsReleased("amd64"))
+	assert.False(t, dependents.IsReleased("shared"))
+
+	assert.Equal(t, []string{"shared"}, dependents.ParentDeleted("index-2"))
+	assert.True(t, dependents.IsReleased("shared"))
+	assert.Empty(t, dependents.ParentDeleted("amd64"))
+}
@@ -3,15 +3,41 @@ package manifest
 import (
 	"encoding/json"
 	"sort"
-	"strings"
 	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/semver"
 )
 
 // Data Structure to hold manifest information
 type Data struct {
-	Digest         string    `yaml:"digest"`
-	Tags           []string  `yaml:"tags"`
-	LastUpdateTime time.Time `yaml:"lastUpdateTime"`
+	Digest               string               `yaml:"digest"`
+	MediaType            string               `yaml:"mediaType"`
+	Tags                 []string             `yaml:"tags"`
+	LastUpdateTime       time.Time            `yaml:"lastUpdateTime"`
+	ChangeableAttributes ChangeableAttributes `yaml:"changeableAttributes"`
+}
+
+// ChangeableAttributes Lock attributes ACR keeps for repositories and manifests.
+// Attributes not reported by the registry are nil
+type ChangeableAttributes struct {
+	DeleteEnabled *bool `yaml:"deleteEnabled"`
+	WriteEnabled  *bool `yaml:"writeEnabled"`
+	ReadEnabled   *bool `yaml:"readEnabled"`
+	ListEnabled   *bool `yaml:"listEnabled"`
+}
+
+// IsLocked Indicates that deletes have been disabled, either explicitly or by disabling writes
+func (attributes ChangeableAttributes) IsLocked() bool {
+	return isDisabled(attributes.DeleteEnabled) || isDisabled(attributes.WriteEnabled)
+}
+
+func isDisabled(enabled *bool) bool {
+	return enabled != nil && !*enabled
+}
+
+// IsLocked Indicates that the manifest is protected from deletion
+func (manifest Data) IsLocked() bool {
+	return manifest.ChangeableAttributes.IsLocked()
 }
 
 // FromData Returns manifests from byte array
@@ -32,18 +58,39 @@ func FromDataSorted(data []byte) ([]Data, error) {
 		return nil, err
 	}
 
-	sort.Slice(manifests, func(i, j int) bool {
-		return manifests[j].LastUpdateTime.After(manifests[i].LastUpdateTime)
-	})
+	SortByLastUpdateTime(manifests)
 	return manifests, nil
 }
 
-var clusterTypes = [...]string{"development", "production", "playground"}
+// SortByLastUpdateTime Sorts manifests by timestamp asc
+func SortByLastUpdateTime(manifests []Data) {
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[j].LastUpdateTime.After(manifests[i].LastUpdateTime)
+	})
+}
 
 // IsTaggedForCurrentClustertype Indicates if manifest is tagged for cluster
-func (manifest Data) IsTaggedForCurrentClustertype(clusterType string) bool {
+func (manifest Data) IsTaggedForCurrentClustertype(classifier *Classifier, clusterType string) bool {
+	return len(manifest.ClusterTypeTags(classifier, clusterType)) > 0
+}
+
+// ClusterTypeTags Returns the tags of the manifest for a cluster type
+func (manifest Data) ClusterTypeTags(classifier *Classifier, clusterType string) []string {
+	tags := make([]string, 0)
+	for _, tag := range manifest.Tags {
+		if classification := classifier.Classify(tag); classification.Category == CategoryClusterType && classification.ClusterType == clusterType {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// IsTaggedForOtherClustertype Indicates that manifest is tagged for any
+// known cluster type other than clusterType
+func (manifest Data) IsTaggedForOtherClustertype(classifier *Classifier, clusterType string) bool {
 	for _, tag := range manifest.Tags {
-		if strings.HasPrefix(tag, clusterType+"-") {
+		if classification := classifier.Classify(tag); classification.Category == CategoryClusterType && classification.ClusterType != clusterType {
 			return true
 		}
 	}
@@ -52,15 +99,50 @@ func (manifest Data) IsTaggedForCurrentClustertype(clusterType string) bool {
 }
 
 // IsNotTaggedForAnyClustertype Indicates that manifest is not tagged for any
-// cluster type
-func (manifest Data) IsNotTaggedForAnyClustertype() bool {
-	for _, clusterType := range clusterTypes {
-		if manifest.IsTaggedForCurrentClustertype(clusterType) {
-			return false
+// known cluster type
+func (manifest Data) IsNotTaggedForAnyClustertype(classifier *Classifier) bool {
+	return !manifest.IsTaggedWith(classifier, CategoryClusterType)
+}
+
+// IsTaggedWith Indicates that manifest has a tag of the category
+func (manifest Data) IsTaggedWith(classifier *Classifier, category Category) bool {
+	for _, tag := range manifest.Tags {
+		if classifier.Classify(tag).Category == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasProtectedTag Indicates that manifest has a protected tag, and is always retained
+func (manifest Data) HasProtectedTag(classifier *Classifier) bool {
+	return manifest.IsTaggedWith(classifier, CategoryProtected)
+}
+
+// HasReleaseTag Indicates that manifest has a release tag
+func (manifest Data) HasReleaseTag(classifier *Classifier) bool {
+	return manifest.IsTaggedWith(classifier, CategoryRelease)
+}
+
+// HasCacheTag Indicates that manifest has a build cache tag
+func (manifest Data) HasCacheTag(classifier *Classifier) bool {
+	return manifest.IsTaggedWith(classifier, CategoryCache)
+}
+
+// ReleaseVersions Returns the semantic versions of the tags classified as release tags, or not classified at all
+func (manifest Data) ReleaseVersions(classifier *Classifier) []semver.Version {
+	versions := make([]semver.Version, 0)
+	for _, tag := range manifest.Tags {
+		if category := classifier.Classify(tag).Category; category != CategoryRelease && category != CategoryNone {
+			continue
+		}
+		if version, ok := semver.Parse(tag); ok {
+			versions = append(versions, version)
 		}
 	}
 
-	return true
+	return versions
 }
 
 // Contains Manifest contains image tag
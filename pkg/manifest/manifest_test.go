@@ -3,6 +3,7 @@ package manifest
 import (
 	"testing"
 
+	"github.com/equinor/radix-acr-cleanup/pkg/semver"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -46,13 +47,13 @@ func TestFromStringData(t *testing.T) {
 	manifests, err := FromData([]byte(testManifest))
 	assert.NoError(t, err)
 	assert.Equal(t, 4, len(manifests))
-	assert.True(t, manifests[0].IsNotTaggedForAnyClustertype())
-	assert.False(t, manifests[1].IsNotTaggedForAnyClustertype())
-	assert.False(t, manifests[2].IsNotTaggedForAnyClustertype())
-	assert.False(t, manifests[3].IsNotTaggedForAnyClustertype())
-	assert.True(t, manifests[1].IsTaggedForCurrentClustertype("development"))
-	assert.True(t, manifests[2].IsTaggedForCurrentClustertype("playground"))
-	assert.True(t, manifests[3].IsTaggedForCurrentClustertype("production"))
+	assert.True(t, manifests[0].IsNotTaggedForAnyClustertype(nil))
+	assert.False(t, manifests[1].IsNotTaggedForAnyClustertype(nil))
+	assert.False(t, manifests[2].IsNotTaggedForAnyClustertype(nil))
+	assert.False(t, manifests[3].IsNotTaggedForAnyClustertype(nil))
+	assert.True(t, manifests[1].IsTaggedForCurrentClustertype(nil, "development"))
+	assert.True(t, manifests[2].IsTaggedForCurrentClustertype(nil, "playground"))
+	assert.True(t, manifests[3].IsTaggedForCurrentClustertype(nil, "production"))
 }
 
 func TestFromStringDataSorted(t *testing.T) {
@@ -64,3 +65,39 @@ func TestFromStringDataSorted(t *testing.T) {
 	assert.Equal(t, "third", manifests[2].Tags[0])
 	assert.Equal(t, "fourth", manifests[3].Tags[0])
 }
+
+func TestIsLocked(t *testing.T) {
+	manifests, err := FromData([]byte(`[
+  {"digest": "sha256:a", "changeableAttributes": {"deleteEnabled": false, "writeEnabled": true}},
+  {"digest": "sha256:b", "changeableAttributes": {"deleteEnabled": true, "writeEnabled": false}},
+  {"digest": "sha256:c", "changeableAttributes": {"deleteEnabled": true, "writeEnabled": true, "readEnabled": false}},
+  {"digest": "sha256:d"}
+]`))
+	assert.NoError(t, err)
+	assert.True(t, manifests[0].IsLocked())
+	assert.True(t, manifests[1].IsLocked())
+	assert.False(t, manifests[2].IsLocked())
+	assert.False(t, manifests[3].IsLocked())
+}
+
+func TestClusterTypeTags(t *testing.T) {
+	manifest := Data{Tags: []string{"1.0", "development-1", "development-2", "playground-1"}}
+
+	assert.Equal(t, []string{"development-1", "development-2"}, manifest.ClusterTypeTags(nil, "development"))
+	assert.Empty(t, manifest.ClusterTypeTags(nil, "production"))
+	assert.True(t, manifest.IsTaggedForOtherClustertype(nil, "development"))
+	assert.True(t, manifest.IsTaggedForOtherClustertype(nil, "playground"))
+	assert.False(t, Data{Tags: []string{"1.0", "development-1"}}.IsTaggedForOtherClustertype(nil, "development"))
+}
+
+func TestReleaseVersions(t *testing.T) {
+	classifier, err := NewClassifier(nil, []Rule{
+		{Category: CategoryRelease, Glob: "v*"},
+		{Category: CategoryProtected, Glob: "9.9.9"},
+	})
+	assert.NoError(t, err)
+
+	manifest := Data{Tags: []string{"v1.2.3", "1.2.4", "9.9.9", "development-1.0.0", "v1.2", "latest"}}
+	assert.Equal(t, []semver.Version{{Major: 1, Minor: 2, Patch: 3}, {Major: 1, Minor: 2, Patch: 4}}, manifest.ReleaseVersions(classifier))
+	assert.Empty(t, Data{Tags: []string{"latest"}}.ReleaseVersions(classifier))
+}
@@ -0,0 +1,30 @@
+package manifest
+
+import "regexp"
+
+// cosignTagPattern Tags cosign gives the signatures, attestations and SBOMs attached to a subject
+var cosignTagPattern = regexp.MustCompile(`^(sha256)-([a-f0-9]{64})\.(sig|att|sbom)$`)
+
+// Subject Returns the digest of the manifest this manifest is attached to by the cosign tag convention,
+// e.g. sha256-<hex>.sig for a signature of sha256:<hex>
+func (manifest Data) Subject() (string, bool) {
+	for _, tag := range manifest.Tags {
+		if match := cosignTagPattern.FindStringSubmatch(tag); match != nil {
+			return match[1] + ":" + match[2], true
+		}
+	}
+	return "", false
+}
+
+// AddReferrers Records referrers, such as signatures and SBOMs, attached to subject
+func (d *Dependents) AddReferrers(subject string, referrers ...string) {
+	d.Add(subject, referrers...)
+	for _, referrer := range referrers {
+		d.referrers[referrer] = true
+	}
+}
+
+// IsReferrer Indicates that digest is attached to a subject
+func (d *Dependents) IsReferrer(digest string) bool {
+	return d.referrers[digest]
+}
@@ -0,0 +1,32 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubject(t *testing.T) {
+	hex := "7f8343822a17acc74c88c96badc2a1a981ad9fc749b4c5194816c0ef01fc9457"
+
+	for _, suffix := range []string{".sig", ".att", ".sbom"} {
+		subject, ok := Data{Tags: []string{"sha256-" + hex + suffix}}.Subject()
+		assert.True(t, ok)
+		assert.Equal(t, "sha256:"+hex, subject)
+	}
+
+	_, ok := Data{Tags: []string{"sha256-" + hex}}.Subject()
+	assert.False(t, ok)
+	_, ok = Data{Tags: []string{"development-1", "latest"}}.Subject()
+	assert.False(t, ok)
+}
+
+func TestReferrers(t *testing.T) {
+	dependents := NewDependents()
+	dependents.Add("index", "amd64")
+	dependents.AddReferrers("index", "signature")
+
+	assert.True(t, dependents.IsReferrer("signature"))
+	assert.False(t, dependents.IsReferrer("amd64"))
+	assert.Equal(t, []string{"amd64", "signature"}, dependents.ParentDeleted("index"))
+}
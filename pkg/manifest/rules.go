@@ -0,0 +1,85 @@
+package manifest
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+const (
+	// CategoryRelease Release tags, e.g. v1.2.3. Manifests with release tags are retained
+	CategoryRelease Category = "release"
+	// CategoryCache Build cache tags. Manifests with cache tags and no cluster type tags are deleted when not in use,
+	// even if untagged manifests are not mandated for deletion
+	CategoryCache Category = "cache"
+	// CategoryProtected Tags such as latest. Manifests with protected tags are always retained
+	CategoryProtected Category = "protected"
+)
+
+// Rule Classifies the tags matching either a glob or a regular expression
+type Rule struct {
+	Category Category `json:"category"`
+	// ClusterType The cluster type of tags classified as cluster-type
+	ClusterType string `json:"clusterType,omitempty"`
+	// Glob Pattern the whole tag must match, with * matching any characters, ? a single character and [] a character class
+	Glob string `json:"glob,omitempty"`
+	// Regex Regular expression matching any part of the tag, unless anchored with ^ and $
+	Regex string `json:"regex,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// compile Validates the rule against the known cluster types, and compiles its regular expression
+func (rule *Rule) compile(clusterTypes ClusterTypes) error {
+	switch rule.Category {
+	case CategoryClusterType:
+		if !clusterTypes.Contains(rule.ClusterType) {
+			return fmt.Errorf("has unknown cluster type %q", rule.ClusterType)
+		}
+	case CategoryRelease, CategoryCache, CategoryProtected:
+		if len(rule.ClusterType) > 0 {
+			return fmt.Errorf("of category %s has a cluster type", rule.Category)
+		}
+	default:
+		return fmt.Errorf("has unknown category %q", rule.Category)
+	}
+
+	switch {
+	case len(rule.Glob) > 0 && len(rule.Regex) > 0:
+		return fmt.Errorf("has both glob and regex")
+	case len(rule.Glob) > 0:
+		if _, err := path.Match(rule.Glob, ""); err != nil {
+			return fmt.Errorf("has invalid glob %q: %w", rule.Glob, err)
+		}
+	case len(rule.Regex) > 0:
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("has invalid regex %q: %w", rule.Regex, err)
+		}
+		rule.regex = regex
+	default:
+		return fmt.Errorf("has neither glob nor regex")
+	}
+	return nil
+}
+
+// matches Indicates that a tag matches the pattern of the rule
+func (rule Rule) matches(tag string) bool {
+	if rule.regex != nil {
+		return rule.regex.MatchString(tag)
+	}
+	matched, _ := path.Match(rule.Glob, tag)
+	return matched
+}
+
+// String Describes the rule
+func (rule Rule) String() string {
+	pattern := "glob " + rule.Glob
+	if len(rule.Regex) > 0 {
+		pattern = "regex " + rule.Regex
+	}
+	if rule.Category == CategoryClusterType {
+		return fmt.Sprintf("%s %s: %s", rule.Category, rule.ClusterType, pattern)
+	}
+	return fmt.Sprintf("%s: %s", rule.Category, pattern)
+}
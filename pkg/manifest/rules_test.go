@@ -0,0 +1,57 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifierAppliesRulesInOrder(t *testing.T) {
+	classifier, err := NewClassifier(nil, []Rule{
+		{Category: CategoryProtected, Glob: "latest"},
+		{Category: CategoryProtected, Glob: "*-latest"},
+		{Category: CategoryRelease, Regex: `^v?\d+\.\d+\.\d+$`},
+		{Category: CategoryCache, Glob: "cache-*"},
+		{Category: CategoryClusterType, ClusterType: "production", Regex: `^prod-\d+-`},
+	})
+	assert.NoError(t, err)
+
+	for tag, expected := range map[string]Classification{
+		"latest":             {Category: CategoryProtected},
+		"development-latest": {Category: CategoryProtected},
+		"v1.2.3":             {Category: CategoryRelease},
+		"1.2.3":              {Category: CategoryRelease},
+		"v1.2.3-rc1":         {Category: CategoryNone},
+		"cache-amd64":        {Category: CategoryCache},
+		"prod-39-abc":        {Category: CategoryClusterType, ClusterType: "production"},
+		"development-abc":    {Category: CategoryClusterType, ClusterType: "development"},
+		"abc":                {Category: CategoryNone},
+	} {
+		assert.Equal(t, expected, classifier.Classify(tag), tag)
+	}
+
+	manifest := Data{Tags: []string{"v1.2.3", "development-abc"}}
+	assert.True(t, manifest.IsTaggedWith(classifier, CategoryRelease))
+	assert.False(t, manifest.IsTaggedWith(classifier, CategoryProtected))
+	assert.True(t, manifest.IsTaggedForCurrentClustertype(classifier, "development"))
+	assert.True(t, Data{Tags: []string{"prod-39-abc"}}.IsTaggedForOtherClustertype(classifier, "development"))
+	assert.True(t, Data{Tags: []string{"development-latest"}}.IsNotTaggedForAnyClustertype(classifier))
+}
+
+func TestNewClassifierRejectsInvalidRules(t *testing.T) {
+	for name, rule := range map[string]Rule{
+		"unknown category":               {Category: "other", Glob: "*"},
+		"missing category":               {Glob: "*"},
+		"unknown cluster type":           {Category: CategoryClusterType, ClusterType: "c2", Glob: "c2-*"},
+		"cluster type of other category": {Category: CategoryRelease, ClusterType: "production", Glob: "v*"},
+		"no pattern":                     {Category: CategoryProtected},
+		"glob and regex":                 {Category: CategoryProtected, Glob: "latest", Regex: "^latest$"},
+		"invalid glob":                   {Category: CategoryProtected, Glob: "[latest"},
+		"invalid regex":                  {Category: CategoryProtected, Regex: "(latest"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewClassifier(nil, []Rule{rule})
+			assert.Error(t, err)
+		})
+	}
+}
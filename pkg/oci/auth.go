@@ -0,0 +1,154 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+)
+
+const (
+	// Token servers may omit expires_in, in which case the token is valid for 60 seconds
+	defaultTokenLifetime = 60 * time.Second
+	tokenExpiryMargin    = 10 * time.Second
+
+	catalogScope = "registry:catalog:*"
+)
+
+var challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type cachedToken struct {
+	value     string
+	expiresOn time.Time
+}
+
+func (t cachedToken) valid() bool {
+	return len(t.value) > 0 && time.Now().Add(tokenExpiryMargin).Before(t.expiresOn)
+}
+
+// challenge Parsed WWW-Authenticate header
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+func parseChallenge(header string) challenge {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	c := challenge{scheme: strings.ToLower(scheme), params: make(map[string]string)}
+	for _, match := range challengeParamPattern.FindAllStringSubmatch(rest, -1) {
+		c.params[strings.ToLower(match[1])] = match[2]
+	}
+	return c
+}
+
+func repositoryScope(repository string, actions ...string) string {
+	return fmt.Sprintf("repository:%s:%s", repository, strings.Join(actions, ","))
+}
+
+// authorize Sets credentials already known to be needed for scope
+func (c *Client) authorize(req *http.Request, scope string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token, ok := c.tokens[scope]; ok && token.valid() {
+		req.Header.Set("Authorization", "Bearer "+token.value)
+	} else if c.basicAuth && len(c.username) > 0 {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// handleChallenge Obtains the credentials requested by a 401 response. Returns false if the challenge cannot be met
+func (c *Client) handleChallenge(ctx context.Context, resp *http.Response, scope string) (bool, error) {
+	ch := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	switch ch.scheme {
+	case "basic":
+		if len(c.username) == 0 {
+			return false, nil
+		}
+		c.mu.Lock()
+		c.basicAuth = true
+		c.mu.Unlock()
+		return true, nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, ch, scope)
+		if err != nil {
+			return false, err
+		}
+		c.mu.Lock()
+		c.tokens[scope] = token
+		c.mu.Unlock()
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// fetchToken Requests a bearer token for scope from the realm of the challenge
+func (c *Client) fetchToken(ctx context.Context, ch challenge, scope string) (cachedToken, error) {
+	realm, ok := ch.params["realm"]
+	if !ok {
+		return cachedToken{}, fmt.Errorf("bearer challenge from %s has no realm", c.host)
+	}
+
+	endpoint, err := url.Parse(realm)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("invalid token realm %s: %w", realm, err)
+	}
+
+	query := endpoint.Query()
+	if service, ok := ch.params["service"]; ok {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	if len(c.username) > 0 {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := registry.Do(c.httpClient, req)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("token request to %s failed: %w", realm, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return cachedToken{}, fmt.Errorf("token request to %s failed: %w", realm, registry.ResponseError(resp))
+	}
+
+	var response struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return cachedToken{}, fmt.Errorf("read token response from %s failed: %w", realm, err)
+	}
+
+	token := cachedToken{value: response.Token, expiresOn: time.Now().Add(defaultTokenLifetime)}
+	if len(token.value) == 0 {
+		token.value = response.AccessToken
+	}
+	if response.ExpiresIn > 0 {
+		token.expiresOn = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
+
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
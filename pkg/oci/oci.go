@@ -0,0 +1,480 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+)
+
+const (
+	pageSize               = 100
+	createdAnnotation      = "org.opencontainers.image.created"
+	dockerContentDigestKey = "Docker-Content-Digest"
+)
+
+// minCreated Creation times before this are taken as unknown, as reproducible builds set fixed times such as the Unix epoch
+var minCreated = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ListRepositoriesError error
+func ListRepositoriesError(host string, cause error) error {
+	return fmt.Errorf("list repositories for registry %s failed: %w", host, cause)
+}
+
+// ListManifestsError error
+func ListManifestsError(repository string, cause error) error {
+	return fmt.Errorf("list manifests for repository %s failed: %w", repository, cause)
+}
+
+// ListIndexChildrenError error
+func ListIndexChildrenError(repository, digest string, cause error) error {
+	return fmt.Errorf("list children of index %s in repository %s failed: %w", digest, repository, cause)
+}
+
+// ListReferrersError error
+func ListReferrersError(repository, digest string, cause error) error {
+	return fmt.Errorf("list referrers of %s in repository %s failed: %w", digest, repository, cause)
+}
+
+// DeleteManifestError error
+func DeleteManifestError(repository, digest string, cause error) error {
+	return fmt.Errorf("delete manifest %s in repository %s failed: %w", digest, repository, cause)
+}
+
+// DeleteRepositoryError error
+func DeleteRepositoryError(repository string, cause error) error {
+	return fmt.Errorf("delete repository %s failed: %w", repository, cause)
+}
+
+// UntagError error
+func UntagError(repository, tag string, cause error) error {
+	return fmt.Errorf("untag %s in repository %s failed: %w", tag, repository, cause)
+}
+
+// Option Configures a Client
+type Option func(*Client)
+
+// WithHTTPClient Sets the HTTP client used for registry and token requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithCredentials Sets username and password used for basic auth and when requesting bearer tokens
+func WithCredentials(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithPlainHTTP Talks to the registry over http instead of https
+func WithPlainHTTP() Option {
+	return func(c *Client) {
+		c.baseURL = "http://" + c.host
+	}
+}
+
+// Client Talks to a registry implementing the OCI Distribution Spec
+//
+// The spec has no way to list untagged manifests, so only tagged manifests are returned by ListManifests
+type Client struct {
+	host       string
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	// now Returns the current time, used for when a manifest is first seen
+	now func() time.Time
+
+	mu        sync.Mutex
+	basicAuth bool
+	tokens    map[string]cachedToken
+	// seen Manifests listed by the previous listing of each repository, by digest
+	seen map[string]map[string]seenManifest
+}
+
+// seenManifest A listed manifest. As manifests are immutable, its content is not read again while it is listed
+type seenManifest struct {
+	mediaType string
+	created   time.Time
+	firstSeen time.Time
+}
+
+var _ registry.Registry = &Client{}
+
+// NewClient Constructor for a Client talking to the registry at host, e.g. localhost:5000
+func NewClient(host string, options ...Option) *Client {
+	c := &Client{
+		host:       host,
+		baseURL:    "https://" + host,
+		httpClient: http.DefaultClient,
+		now:        time.Now,
+		tokens:     make(map[string]cachedToken),
+		seen:       make(map[string]map[string]seenManifest),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// ListRepositories Is all available repositories in the registry, fetched one page at a time
+func (c *Client) ListRepositories(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		next := fmt.Sprintf("/v2/_catalog?n=%d", pageSize)
+		for len(next) > 0 {
+			var page struct {
+				Repositories []string `json:"repositories"`
+			}
+
+			link, err := c.getJSON(ctx, next, catalogScope, &page)
+			if err != nil {
+				yield("", ListRepositoriesError(c.host, err))
+				return
+			}
+
+			for _, repository := range page.Repositories {
+				if !yield(repository, nil) {
+					return
+				}
+			}
+			next = link
+		}
+	}
+}
+
+// GetRepository Returns only the name of the repository, as the OCI Distribution Spec has no lock attributes
+func (c *Client) GetRepository(_ context.Context, repository string) (registry.Repository, error) {
+	return registry.Repository{Name: repository}, nil
+}
+
+// ListManifests Lists all tagged manifests for a single repository, sorted by creation time asc
+//
+// Tags are listed page by page, but as tags must be grouped by digest and sorted by time,
+// the manifests are only yielded once every tag has been resolved.
+// As the registry does not tell when a manifest was pushed, LastUpdateTime is the later of its creation time
+// and when the client first listed it, so that an image built long before it was pushed is not taken as old
+// until it has been listed for a while
+func (c *Client) ListManifests(ctx context.Context, repository string) iter.Seq2[manifest.Data, error] {
+	return func(yield func(manifest.Data, error) bool) {
+		manifests, err := c.listManifests(ctx, repository)
+		if err != nil {
+			yield(manifest.Data{}, ListManifestsError(repository, err))
+			return
+		}
+
+		for _, m := range manifests {
+			if !yield(m, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) listManifests(ctx context.Context, repository string) ([]manifest.Data, error) {
+	byDigest := make(map[string]*manifest.Data)
+	manifests := make([]*manifest.Data, 0)
+	for tag, err := range c.listTags(ctx, repository) {
+		if err != nil {
+			return nil, err
+		}
+
+		digest, err := c.resolve(ctx, repository, tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing, ok := byDigest[digest]; ok {
+			existing.Tags = append(existing.Tags, tag)
+			continue
+		}
+
+		m := &manifest.Data{Digest: digest, Tags: []string{tag}}
+		byDigest[digest] = m
+		manifests = append(manifests, m)
+	}
+
+	c.mu.Lock()
+	previous := c.seen[repository]
+	c.mu.Unlock()
+
+	listed := make(map[string]seenManifest, len(manifests))
+	result := make([]manifest.Data, 0, len(manifests))
+	for _, m := range manifests {
+		seen, ok := previous[m.Digest]
+		if !ok {
+			content, err := c.getManifest(ctx, repository, m.Digest)
+			if err != nil {
+				return nil, err
+			}
+			created, err := c.created(ctx, repository, content)
+			if err != nil {
+				return nil, err
+			}
+			seen = seenManifest{mediaType: content.MediaType, created: created, firstSeen: c.now()}
+		}
+		listed[m.Digest] = seen
+		m.MediaType = seen.mediaType
+		m.LastUpdateTime = seen.created
+		result = append(result, *m)
+	}
+
+	manifest.SortByLastUpdateTime(result)
+	for i := range result {
+		if seen := listed[result[i].Digest]; seen.firstSeen.After(seen.created) {
+			result[i].LastUpdateTime = seen.firstSeen
+		}
+	}
+
+	c.mu.Lock()
+	c.seen[repository] = listed
+	c.mu.Unlock()
+	return result, nil
+}
+
+// ListIndexChildren Lists the digests of the platform manifests referenced by an image index
+func (c *Client) ListIndexChildren(ctx context.Context, repository, digest string) ([]string, error) {
+	content, err := c.getManifest(ctx, repository, digest)
+	if err != nil {
+		return nil, ListIndexChildrenError(repository, digest, err)
+	}
+
+	children := make([]string, 0, len(content.Manifests))
+	for _, child := range content.Manifests {
+		children = append(children, child.Digest)
+	}
+	return children, nil
+}
+
+// ListReferrers Lists the digests of the manifests attached to a subject through the referrers API,
+// fetched one page at a time
+func (c *Client) ListReferrers(ctx context.Context, repository, digest string) ([]string, error) {
+	referrers := make([]string, 0)
+	next := fmt.Sprintf("/v2/%s/referrers/%s", repository, digest)
+	for len(next) > 0 {
+		var page struct {
+			Manifests []struct {
+				Digest string `json:"digest"`
+			} `json:"manifests"`
+		}
+
+		link, err := c.getJSONAccept(ctx, next, repositoryScope(repository, "pull"), manifest.MediaTypeOCIIndex, &page)
+		if err != nil {
+			return nil, ListReferrersError(repository, digest, err)
+		}
+
+		for _, referrer := range page.Manifests {
+			referrers = append(referrers, referrer.Digest)
+		}
+		next = link
+	}
+	return referrers, nil
+}
+
+// DeleteManifest Will delete a single manifest
+func (c *Client) DeleteManifest(ctx context.Context, repository string, manifest manifest.Data) error {
+	if err := c.deleteReference(ctx, repository, manifest.Digest); err != nil {
+		return DeleteManifestError(repository, manifest.Digest, err)
+	}
+
+	c.mu.Lock()
+	delete(c.seen[repository], manifest.Digest)
+	c.mu.Unlock()
+	return nil
+}
+
+// Untag Removes a single tag, leaving the manifest in place
+func (c *Client) Untag(ctx context.Context, repository, tag string) error {
+	if err := c.deleteReference(ctx, repository, tag); err != nil {
+		return UntagError(repository, tag, err)
+	}
+	return nil
+}
+
+// DeleteRepository Is not supported, as the OCI Distribution Spec has no way to delete a repository
+func (c *Client) DeleteRepository(_ context.Context, repository string) error {
+	return DeleteRepositoryError(repository, errors.ErrUnsupported)
+}
+
+func (c *Client) deleteReference(ctx context.Context, repository, reference string) error {
+	path := fmt.Sprintf("/v2/%s/manifests/%s", repository, reference)
+
+	resp, err := c.do(ctx, http.MethodDelete, path, repositoryScope(repository, "delete"), "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return registry.ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (c *Client) listTags(ctx context.Context, repository string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		next := fmt.Sprintf("/v2/%s/tags/list?n=%d", repository, pageSize)
+		for len(next) > 0 {
+			var page struct {
+				Tags []string `json:"tags"`
+			}
+
+			link, err := c.getJSON(ctx, next, repositoryScope(repository, "pull"), &page)
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			for _, tag := range page.Tags {
+				if !yield(tag, nil) {
+					return
+				}
+			}
+			next = link
+		}
+	}
+}
+
+// resolve Returns the digest a tag points to
+func (c *Client) resolve(ctx context.Context, repository, tag string) (string, error) {
+	path := fmt.Sprintf("/v2/%s/manifests/%s", repository, tag)
+
+	resp, err := c.do(ctx, http.MethodHead, path, repositoryScope(repository, "pull"), strings.Join(manifest.MediaTypes, ", "))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", registry.ResponseError(resp)
+	}
+
+	digest := resp.Header.Get(dockerContentDigestKey)
+	if len(digest) == 0 {
+		return "", fmt.Errorf("registry returned no digest for tag %s", tag)
+	}
+
+	return digest, nil
+}
+
+// manifestContent Fields of image manifests and indexes used to find the creation time
+type manifestContent struct {
+	MediaType   string            `json:"mediaType"`
+	Annotations map[string]string `json:"annotations"`
+	Config      *struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"`
+}
+
+// getManifest Reads an image manifest or index
+func (c *Client) getManifest(ctx context.Context, repository, digest string) (manifestContent, error) {
+	var content manifestContent
+	path := fmt.Sprintf("/v2/%s/manifests/%s", repository, digest)
+	_, err := c.getJSONAccept(ctx, path, repositoryScope(repository, "pull"), strings.Join(manifest.MediaTypes, ", "), &content)
+	return content, err
+}
+
+// created Returns the creation time of a manifest from its annotations, its image config,
+// or for indexes the first child manifest. These are build times, as registries do not tell when a manifest was pushed.
+// Returns zero time, meaning unknown, if none is available or the time is before minCreated
+func (c *Client) created(ctx context.Context, repository string, content manifestContent) (time.Time, error) {
+	if created, err := time.Parse(time.RFC3339, content.Annotations[createdAnnotation]); err == nil && !created.Before(minCreated) {
+		return created, nil
+	}
+
+	if content.Config != nil && len(content.Config.Digest) > 0 {
+		var config struct {
+			Created time.Time `json:"created"`
+		}
+		path := fmt.Sprintf("/v2/%s/blobs/%s", repository, content.Config.Digest)
+		if _, err := c.getJSON(ctx, path, repositoryScope(repository, "pull"), &config); err != nil {
+			return time.Time{}, err
+		}
+		if config.Created.Before(minCreated) {
+			return time.Time{}, nil
+		}
+		return config.Created, nil
+	}
+
+	if len(content.Manifests) > 0 {
+		child, err := c.getManifest(ctx, repository, content.Manifests[0].Digest)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return c.created(ctx, repository, child)
+	}
+
+	return time.Time{}, nil
+}
+
+// getJSON Decodes the response of a GET request into target and returns the path of the next page, if any
+func (c *Client) getJSON(ctx context.Context, path, scope string, target interface{}) (string, error) {
+	return c.getJSONAccept(ctx, path, scope, "application/json", target)
+}
+
+func (c *Client) getJSONAccept(ctx context.Context, path, scope, accept string, target interface{}) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, path, scope, accept)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", registry.ResponseError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return "", err
+	}
+
+	return registry.NextLink(resp.Header.Get("Link")), nil
+}
+
+// do Sends a request, answering a single authentication challenge if the registry requires it
+func (c *Client) do(ctx context.Context, method, path, scope, accept string) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", accept)
+		}
+		c.authorize(req, scope)
+		return registry.Do(c.httpClient, req)
+	}
+
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	ok, err := c.handleChallenge(ctx, resp, scope)
+	if err != nil {
+		drain(resp)
+		return nil, err
+	}
+	if !ok {
+		return resp, nil
+	}
+	drain(resp)
+
+	return send()
+}
@@ -0,0 +1,251 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDistribution Minimal OCI distribution registry requiring bearer tokens from its own token endpoint
+type fakeDistribution struct {
+	mu        sync.Mutex
+	url       string
+	bearer    bool
+	tokens    int
+	deleted   []string
+	tags      map[string]map[string]string // repository -> tag -> digest
+	created   map[string]string            // digest -> created
+	manifests map[string]string            // digest -> config digest
+}
+
+func newFakeDistribution(bearer bool) (*fakeDistribution, *httptest.Server) {
+	f := &fakeDistribution{
+		bearer:    bearer,
+		tags:      make(map[string]map[string]string),
+		created:   make(map[string]string),
+		manifests: make(map[string]string),
+	}
+	server := httptest.NewServer(f)
+	f.url = server.URL
+	return f, server
+}
+
+func (f *fakeDistribution) addImage(repository, digest, created string, tags ...string) {
+	if f.tags[repository] == nil {
+		f.tags[repository] = make(map[string]string)
+	}
+	for _, tag := range tags {
+		f.tags[repository][tag] = digest
+	}
+	f.manifests[digest] = "config-" + digest
+	f.created["config-"+digest] = created
+}
+
+func (f *fakeDistribution) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.tokens++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"token": "token:" + r.URL.Query().Get("scope"), "expires_in": 300})
+		return
+	}
+
+	if f.bearer && !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token:") {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, f.url))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !f.bearer {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/"), "/")
+	switch {
+	case r.URL.Path == "/v2/_catalog":
+		repositories := make([]string, 0)
+		for repository := range f.tags {
+			repositories = append(repositories, repository)
+		}
+		sort.Strings(repositories)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"repositories": repositories})
+	case len(parts) == 3 && parts[1] == "tags":
+		tags := make([]string, 0)
+		for tag := range f.tags[parts[0]] {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		last := r.URL.Query().Get("last")
+		start := sort.SearchStrings(tags, last)
+		if len(last) > 0 {
+			start++
+		}
+		page := tags[min(start, len(tags)):min(start+2, len(tags))]
+		if start+2 < len(tags) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=2&last=%s>; rel="next"`, parts[0], page[len(page)-1]))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": parts[0], "tags": page})
+	case len(parts) == 3 && parts[1] == "manifests":
+		reference := parts[2]
+		digest, ok := f.tags[parts[0]][reference]
+		if !ok {
+			digest = reference
+		}
+		if r.Method == http.MethodDelete {
+			f.deleted = append(f.deleted, parts[0]+":"+reference)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		config, ok := f.manifests[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set(dockerContentDigestKey, digest)
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		if r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"config":    map[string]string{"digest": config},
+			})
+		}
+	case len(parts) == 3 && parts[1] == "blobs":
+		config := make(map[string]string)
+		if created := f.created[parts[2]]; len(created) > 0 {
+			config["created"] = created
+		}
+		_ = json.NewEncoder(w).Encode(config)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	distribution, server := newFakeDistribution(true)
+	defer server.Close()
+	distribution.addImage("app", "sha256:a", "2020-01-01T00:00:00Z", "1")
+	distribution.addImage("other", "sha256:b", "2020-01-01T00:00:00Z", "1")
+
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"), WithPlainHTTP(), WithCredentials("user", "secret"))
+	repositories, err := registry.Collect(client.ListRepositories(context.Background()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app", "other"}, repositories)
+}
+
+func TestListManifestsGroupsTagsByDigest(t *testing.T) {
+	distribution, server := newFakeDistribution(true)
+	defer server.Close()
+	distribution.addImage("app", "sha256:new", "2020-01-02T00:00:00Z", "development-2", "2")
+	distribution.addImage("app", "sha256:old", "2020-01-01T00:00:00Z", "development-1", "1", "latest")
+
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"), WithPlainHTTP(), WithCredentials("user", "secret"))
+	client.now = func() time.Time { return time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC) }
+	manifests, err := registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 2)
+	assert.Equal(t, "sha256:old", manifests[0].Digest)
+	assert.ElementsMatch(t, []string{"1", "development-1", "latest"}, manifests[0].Tags)
+	assert.Equal(t, manifest.MediaTypeOCIManifest, manifests[0].MediaType)
+	assert.Equal(t, "2020-01-01T00:00:00Z", manifests[0].LastUpdateTime.Format("2006-01-02T15:04:05Z07:00"))
+	assert.Equal(t, "sha256:new", manifests[1].Digest)
+	assert.ElementsMatch(t, []string{"2", "development-2"}, manifests[1].Tags)
+	assert.Equal(t, 1, distribution.tokens, "pull token for the repository should be reused")
+}
+
+func TestListManifestsWithoutPlausibleCreationTime(t *testing.T) {
+	distribution, server := newFakeDistribution(true)
+	defer server.Close()
+	distribution.addImage("app", "sha256:none", "", "development-1")
+	distribution.addImage("app", "sha256:epoch", "1970-01-01T00:00:00Z", "development-2")
+
+	firstSeen := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"), WithPlainHTTP(), WithCredentials("user", "secret"))
+	client.now = func() time.Time { return firstSeen }
+	manifests, err := registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 2)
+	for _, manifest := range manifests {
+		assert.Equal(t, firstSeen, manifest.LastUpdateTime, manifest.Digest)
+	}
+}
+
+func TestListManifestsIsNotOlderThanFirstSeen(t *testing.T) {
+	distribution, server := newFakeDistribution(true)
+	defer server.Close()
+	distribution.addImage("app", "sha256:built-before", "2020-01-01T00:00:00Z", "development-1")
+	distribution.addImage("app", "sha256:built-after", "2020-03-01T00:00:00Z", "development-2")
+
+	firstSeen := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+	now := firstSeen
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"), WithPlainHTTP(), WithCredentials("user", "secret"))
+	client.now = func() time.Time { return now }
+	manifests, err := registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 2)
+	assert.Equal(t, "sha256:built-before", manifests[0].Digest, "sorted by creation time")
+	assert.Equal(t, firstSeen, manifests[0].LastUpdateTime)
+	assert.Equal(t, "2020-03-01T00:00:00Z", manifests[1].LastUpdateTime.Format(time.RFC3339))
+
+	now = firstSeen.Add(48 * time.Hour)
+	manifests, err = registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	assert.Equal(t, firstSeen, manifests[0].LastUpdateTime, "first seen is kept between listings")
+
+	require.NoError(t, client.DeleteManifest(context.Background(), "app", manifests[0]))
+	manifests, err = registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	assert.Equal(t, now, manifests[0].LastUpdateTime, "deleted manifests are forgotten")
+}
+
+func TestDeleteAndUntagWithBasicAuth(t *testing.T) {
+	distribution, server := newFakeDistribution(false)
+	defer server.Close()
+	distribution.addImage("app", "sha256:a", "2020-01-01T00:00:00Z", "development-1")
+
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"), WithPlainHTTP(), WithCredentials("user", "secret"))
+	manifests, err := registry.Collect(client.ListManifests(context.Background(), "app"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+
+	require.NoError(t, client.Untag(context.Background(), "app", "development-1"))
+	require.NoError(t, client.DeleteManifest(context.Background(), "app", manifests[0]))
+	assert.Equal(t, []string{"app:development-1", "app:sha256:a"}, distribution.deleted)
+}
+
+func TestUnauthorizedWithoutCredentials(t *testing.T) {
+	_, server := newFakeDistribution(false)
+	defer server.Close()
+
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"), WithPlainHTTP())
+	_, err := registry.Collect(client.ListRepositories(context.Background()))
+	assert.ErrorIs(t, err, registry.ErrUnauthorized)
+}
+
+func TestParseChallenge(t *testing.T) {
+	c := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:samalba/my-app:pull,push"`)
+	assert.Equal(t, "bearer", c.scheme)
+	assert.Equal(t, "https://auth.docker.io/token", c.params["realm"])
+	assert.Equal(t, "registry.docker.io", c.params["service"])
+	assert.Equal(t, "repository:samalba/my-app:pull,push", c.params["scope"])
+}
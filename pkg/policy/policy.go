@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"sigs.k8s.io/yaml"
+)
+
+// Policy Cleanup settings per registry, and the rules classifying and retaining tags of all registries
+type Policy struct {
+	Registries       []Registry        `json:"registries"`
+	TagRules         []manifest.Rule   `json:"tagRules,omitempty"`
+	ReleaseRetention ReleaseRetentions `json:"releaseRetention,omitempty"`
+}
+
+// Registry Cleanup settings of a single registry. Settings left out use the value of the corresponding flag
+type Registry struct {
+	Name                 string   `json:"name"`
+	Whitelisted          []string `json:"whitelisted,omitempty"`
+	DeleteUntagged       *bool    `json:"deleteUntagged,omitempty"`
+	RetainLatestUntagged *int     `json:"retainLatestUntagged,omitempty"`
+	RetainLatestTagged   *int     `json:"retainLatestTagged,omitempty"`
+	Aliases              []string `json:"aliases,omitempty"`
+}
+
+// ReleaseRetention Semantic version retention of the release tags in repositories matching a glob
+type ReleaseRetention struct {
+	Repositories    string `json:"repositories"`
+	PatchesPerMinor int    `json:"patchesPerMinor"`
+	MinorsPerMajor  int    `json:"minorsPerMajor"`
+}
+
+// ReleaseRetentions Release retentions, of which the first matching a repository applies
+type ReleaseRetentions []ReleaseRetention
+
+// Of Returns the release retention of a repository, if any
+func (retentions ReleaseRetentions) Of(repository string) (ReleaseRetention, bool) {
+	for _, retention := range retentions {
+		if retention.Matches(repository) {
+			return retention, true
+		}
+	}
+	return ReleaseRetention{}, false
+}
+
+// Matches Indicates that the retention applies to a repository
+func (retention ReleaseRetention) Matches(repository string) bool {
+	matched, _ := path.Match(retention.Repositories, repository)
+	return matched
+}
+
+// isValidGlob Indicates that a glob is non-empty and well-formed
+func isValidGlob(glob string) bool {
+	_, err := path.Match(glob, "")
+	return len(glob) > 0 && err == nil
+}
+
+// ReadFile Reads a policy from a YAML or JSON file
+func ReadFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file %s failed: %w", path, err)
+	}
+
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("parse policy file %s failed: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, registry := range policy.Registries {
+		if len(registry.Name) == 0 {
+			return nil, fmt.Errorf("policy file %s has a registry without name", path)
+		}
+		if seen[registry.Name] {
+			return nil, fmt.Errorf("policy file %s has registry %s more than once", path, registry.Name)
+		}
+		seen[registry.Name] = true
+	}
+
+	for i, retention := range policy.ReleaseRetention {
+		if !isValidGlob(retention.Repositories) {
+			return nil, fmt.Errorf("policy file %s has release retention %d with invalid repositories %q", path, i+1, retention.Repositories)
+		}
+		if retention.PatchesPerMinor < 1 || retention.MinorsPerMajor < 1 {
+			return nil, fmt.Errorf("policy file %s has release retention %d keeping less than one patch per minor or minor per major", path, i+1)
+		}
+	}
+
+	return &policy, nil
+}
+
+// Rules Returns the tag rules of the policy
+func (policy *Policy) Rules() []manifest.Rule {
+	if policy == nil {
+		return nil
+	}
+	return policy.TagRules
+}
+
+// Retention Returns the release retentions of the policy
+func (policy *Policy) Retention() ReleaseRetentions {
+	if policy == nil {
+		return nil
+	}
+	return policy.ReleaseRetention
+}
+
+// Registry Returns the settings of a registry, if the policy has any
+func (policy *Policy) Registry(name string) (Registry, bool) {
+	if policy == nil {
+		return Registry{}, false
+	}
+
+	for _, registry := range policy.Registries {
+		if registry.Name == name {
+			return registry, true
+		}
+	}
+	return Registry{}, false
+}
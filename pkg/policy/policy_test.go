@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+registries:
+- name: radixdev
+  whitelisted: [radix-operator]
+  deleteUntagged: true
+- name: radixcache
+  retainLatestUntagged: 0
+`), 0600))
+
+	policy, err := ReadFile(path)
+	require.NoError(t, err)
+
+	dev, ok := policy.Registry("radixdev")
+	require.True(t, ok)
+	assert.Equal(t, []string{"radix-operator"}, dev.Whitelisted)
+	require.NotNil(t, dev.DeleteUntagged)
+	assert.True(t, *dev.DeleteUntagged)
+	assert.Nil(t, dev.RetainLatestUntagged)
+
+	cache, ok := policy.Registry("radixcache")
+	require.True(t, ok)
+	assert.Nil(t, cache.Whitelisted)
+	require.NotNil(t, cache.RetainLatestUntagged)
+	assert.Equal(t, 0, *cache.RetainLatestUntagged)
+
+	_, ok = policy.Registry("other")
+	assert.False(t, ok)
+}
+
+func TestReadFileRejectsInvalidPolicy(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown field":           "registries:\n- name: radixdev\n  whitelist: [radix-operator]\n",
+		"missing name":            "registries:\n- deleteUntagged: true\n",
+		"duplicate registry":      "registries:\n- name: radixdev\n- name: radixdev\n",
+		"unknown rule field":      "tagRules:\n- category: protected\n  pattern: latest\n",
+		"invalid retention glob":  "releaseRetention:\n- repositories: '[base'\n  patchesPerMinor: 1\n  minorsPerMajor: 1\n",
+		"retention keeps nothing": "releaseRetention:\n- repositories: base\n  patchesPerMinor: 0\n  minorsPerMajor: 1\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+			_, err := ReadFile(path)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRegistryOfNilPolicy(t *testing.T) {
+	var policy *Policy
+	_, ok := policy.Registry("radixdev")
+	assert.False(t, ok)
+}
+
+func TestReadFileWithTagRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+tagRules:
+- category: protected
+  glob: latest
+- category: release
+  regex: '^v\d+\.\d+\.\d+$'
+- category: cluster-type
+  clusterType: production
+  glob: prod-39-*
+`), 0600))
+
+	policy, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, policy.Registries)
+	assert.Equal(t, []manifest.Rule{
+		{Category: manifest.CategoryProtected, Glob: "latest"},
+		{Category: manifest.CategoryRelease, Regex: `^v\d+\.\d+\.\d+$`},
+		{Category: manifest.CategoryClusterType, ClusterType: "production", Glob: "prod-39-*"},
+	}, policy.Rules())
+	assert.Nil(t, (*Policy)(nil).Rules())
+}
+
+func TestReadFileWithReleaseRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+releaseRetention:
+- repositories: base-images/*
+  patchesPerMinor: 3
+  minorsPerMajor: 2
+- repositories: "*"
+  patchesPerMinor: 1
+  minorsPerMajor: 1
+`), 0600))
+
+	policy, err := ReadFile(path)
+	require.NoError(t, err)
+
+	retention, ok := policy.Retention().Of("base-images/dotnet")
+	require.True(t, ok)
+	assert.Equal(t, ReleaseRetention{Repositories: "base-images/*", PatchesPerMinor: 3, MinorsPerMajor: 2}, retention)
+	retention, ok = policy.Retention().Of("radix-operator")
+	require.True(t, ok)
+	assert.Equal(t, 1, retention.PatchesPerMinor)
+
+	_, ok = (*Policy)(nil).Retention().Of("radix-operator")
+	assert.False(t, ok)
+}
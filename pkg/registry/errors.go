@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Kinds of registry errors. Use errors.Is to test for them
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrThrottled    = errors.New("throttled")
+	ErrLocked       = errors.New("locked")
+	ErrUnavailable  = errors.New("unavailable")
+)
+
+// Error A failed registry operation, classified by Kind
+type Error struct {
+	Kind       error
+	StatusCode int
+	Code       string
+	Message    string
+	Cause      error
+}
+
+func (e *Error) Error() string {
+	var parts []string
+	if e.StatusCode > 0 {
+		parts = append(parts, fmt.Sprintf("status %d", e.StatusCode))
+	}
+	if len(e.Code) > 0 {
+		parts = append(parts, e.Code)
+	}
+	if len(e.Message) > 0 {
+		parts = append(parts, e.Message)
+	}
+	if e.Cause != nil {
+		parts = append(parts, e.Cause.Error())
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, strings.Join(parts, ": "))
+}
+
+// Unwrap Makes both the kind and the cause available to errors.Is and errors.As
+func (e *Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Cause}
+}
+
+// Reason Short name of the kind of err, for use as a metrics label
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, ErrThrottled):
+		return "throttled"
+	case errors.Is(err, ErrLocked):
+		return "locked"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+// NetworkError Classifies an error returned when sending a request as ErrUnavailable
+func NetworkError(cause error) error {
+	return &Error{Kind: ErrUnavailable, Cause: cause}
+}
+
+// AuthError Classifies a failure to acquire credentials as ErrUnauthorized
+func AuthError(cause error) error {
+	var registryError *Error
+	if errors.As(cause, &registryError) && !errors.Is(cause, ErrUnauthorized) {
+		return cause
+	}
+	return &Error{Kind: ErrUnauthorized, Cause: cause}
+}
+
+// ResponseError Builds a typed error from a response with an unexpected status code,
+// using the error code of an OCI or ACR error body when present
+func ResponseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+	e := &Error{StatusCode: resp.StatusCode}
+
+	var errorBody struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &errorBody); err == nil && len(errorBody.Errors) > 0 {
+		e.Code = errorBody.Errors[0].Code
+		e.Message = errorBody.Errors[0].Message
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	if resp.Request != nil {
+		e.Message = strings.TrimSpace(fmt.Sprintf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, e.Message))
+	}
+
+	e.Kind = kindOf(resp.StatusCode, e.Code, e.Message)
+	return e
+}
+
+func kindOf(statusCode int, code, message string) error {
+	lowerMessage := strings.ToLower(message)
+	isLocked := strings.Contains(lowerMessage, "disallowed") || strings.Contains(lowerMessage, "locked")
+	switch {
+	case (statusCode == http.StatusMethodNotAllowed || statusCode == http.StatusForbidden) && isLocked:
+		// ACR rejects writes to manifests and repositories with deleteEnabled or writeEnabled set to false
+		return ErrLocked
+	case statusCode == http.StatusUnauthorized, code == "UNAUTHORIZED":
+		return ErrUnauthorized
+	case statusCode == http.StatusForbidden, code == "DENIED":
+		// The credentials are valid, but may lack permissions on a single repository only
+		return ErrForbidden
+	case statusCode == http.StatusNotFound, code == "NAME_UNKNOWN", code == "MANIFEST_UNKNOWN", code == "BLOB_UNKNOWN":
+		return ErrNotFound
+	case statusCode == http.StatusTooManyRequests, code == "TOOMANYREQUESTS":
+		return ErrThrottled
+	case statusCode >= http.StatusInternalServerError:
+		return ErrUnavailable
+	default:
+		return fmt.Errorf("unexpected status %d", statusCode)
+	}
+}
@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func response(statusCode int, body string) *http.Response {
+	req, _ := http.NewRequest(http.MethodDelete, "https://registry.example.com/v2/app/manifests/sha256:a", nil)
+	return &http.Response{StatusCode: statusCode, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+}
+
+func TestResponseErrorKinds(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		body       string
+		expect     error
+		reason     string
+	}{
+		{http.StatusNotFound, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`, ErrNotFound, "not_found"},
+		{http.StatusBadRequest, `{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known to registry"}]}`, ErrNotFound, "not_found"},
+		{http.StatusUnauthorized, `{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`, ErrUnauthorized, "unauthorized"},
+		{http.StatusForbidden, `{"errors":[{"code":"DENIED","message":"access denied"}]}`, ErrForbidden, "forbidden"},
+		{http.StatusMethodNotAllowed, `{"errors":[{"code":"UNSUPPORTED","message":"The operation is disallowed on this registry, repository or image."}]}`, ErrLocked, "locked"},
+		{http.StatusForbidden, `{"errors":[{"code":"DENIED","message":"The operation is disallowed on this registry, repository or image."}]}`, ErrLocked, "locked"},
+		{http.StatusTooManyRequests, `{"errors":[{"code":"TOOMANYREQUESTS","message":"too many requests"}]}`, ErrThrottled, "throttled"},
+		{http.StatusServiceUnavailable, `upstream unavailable`, ErrUnavailable, "unavailable"},
+	}
+
+	for _, test := range tests {
+		err := ResponseError(response(test.statusCode, test.body))
+		assert.ErrorIs(t, err, test.expect, test.body)
+		assert.Equal(t, test.reason, Reason(err), test.body)
+	}
+}
+
+func TestResponseErrorKeepsDetails(t *testing.T) {
+	err := ResponseError(response(http.StatusNotFound, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+
+	var registryError *Error
+	assert.True(t, errors.As(err, &registryError))
+	assert.Equal(t, http.StatusNotFound, registryError.StatusCode)
+	assert.Equal(t, "MANIFEST_UNKNOWN", registryError.Code)
+	assert.ErrorContains(t, err, "DELETE /v2/app/manifests/sha256:a: manifest unknown")
+}
+
+func TestUnexpectedStatusIsUnknown(t *testing.T) {
+	err := ResponseError(response(http.StatusBadRequest, "bad request"))
+	assert.Equal(t, "unknown", Reason(err))
+	assert.ErrorContains(t, err, "400")
+}
+
+func TestMethodNotAllowedIsOnlyLockedWhenDisallowed(t *testing.T) {
+	err := ResponseError(response(http.StatusMethodNotAllowed, `{"errors":[{"code":"UNSUPPORTED","message":"The operation is unsupported."}]}`))
+	assert.NotErrorIs(t, err, ErrLocked)
+	assert.Equal(t, "unknown", Reason(err))
+	assert.ErrorContains(t, err, "405")
+}
+
+func TestAuthErrorKeepsClassifiedCause(t *testing.T) {
+	unavailable := NetworkError(errors.New("connection refused"))
+	assert.ErrorIs(t, AuthError(unavailable), ErrUnavailable)
+	assert.NotErrorIs(t, AuthError(unavailable), ErrUnauthorized)
+	assert.ErrorIs(t, AuthError(errors.New("invalid client secret")), ErrUnauthorized)
+}
@@ -0,0 +1,351 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+	"github.com/equinor/radix-acr-cleanup/pkg/registry"
+)
+
+// Method names recorded in Call
+const (
+	ListRepositories  = "ListRepositories"
+	GetRepository     = "GetRepository"
+	ListManifests     = "ListManifests"
+	ListIndexChildren = "ListIndexChildren"
+	ListReferrers     = "ListReferrers"
+	DeleteManifest    = "DeleteManifest"
+	Untag             = "Untag"
+	DeleteRepository  = "DeleteRepository"
+)
+
+// Call A recorded call to the fake registry
+type Call struct {
+	Method     string
+	Repository string
+	Digest     string
+	Tag        string
+}
+
+// Registry In-memory registry.Registry recording all calls
+type Registry struct {
+	mu           sync.Mutex
+	repositories map[string][]manifest.Data
+	attributes   map[string]manifest.ChangeableAttributes
+	updated      map[string]time.Time
+	children     map[string][]string
+	referrers    map[string][]string
+	errors       map[string]error
+	calls        []Call
+}
+
+var _ registry.Registry = &Registry{}
+
+// New Constructor for an empty fake registry
+func New() *Registry {
+	return &Registry{
+		repositories: make(map[string][]manifest.Data),
+		attributes:   make(map[string]manifest.ChangeableAttributes),
+		updated:      make(map[string]time.Time),
+		children:     make(map[string][]string),
+		referrers:    make(map[string][]string),
+		errors:       make(map[string]error),
+	}
+}
+
+// AddManifests Adds manifests to a repository, creating it if needed
+func (r *Registry) AddManifests(repository string, manifests ...manifest.Data) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.repositories[repository] = append(r.repositories[repository], manifests...)
+	return r
+}
+
+// AddIndex Adds an image index referencing children, which must be added separately
+func (r *Registry) AddIndex(repository string, index manifest.Data, children ...string) *Registry {
+	if len(index.MediaType) == 0 {
+		index.MediaType = manifest.MediaTypeOCIIndex
+	}
+	r.AddManifests(repository, index)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.children[childrenKey(repository, index.Digest)] = children
+	return r
+}
+
+// AddReferrers Adds manifests attached to subject
+func (r *Registry) AddReferrers(repository, subject string, referrers ...manifest.Data) *Registry {
+	r.AddManifests(repository, referrers...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, referrer := range referrers {
+		r.referrers[childrenKey(repository, subject)] = append(r.referrers[childrenKey(repository, subject)], referrer.Digest)
+	}
+	return r
+}
+
+// SetRepositoryAttributes Sets the lock attributes of a repository
+func (r *Registry) SetRepositoryAttributes(repository string, attributes manifest.ChangeableAttributes) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attributes[repository] = attributes
+	return r
+}
+
+// SetRepositoryUpdated Sets the last update time of a repository
+func (r *Registry) SetRepositoryUpdated(repository string, lastUpdateTime time.Time) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.updated[repository] = lastUpdateTime
+	return r
+}
+
+// SetError Makes method fail with err for repository. An empty repository matches all repositories
+func (r *Registry) SetError(method, repository string, err error) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errors[errorKey(method, repository)] = err
+	return r
+}
+
+// Calls Returns all calls made to the registry
+func (r *Registry) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.calls)
+}
+
+// CallsTo Returns calls to a single method
+func (r *Registry) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range r.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Manifests Returns the manifests currently stored in a repository
+func (r *Registry) Manifests(repository string) []manifest.Data {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.repositories[repository])
+}
+
+// ListRepositories Lists repositories in name order
+func (r *Registry) ListRepositories(_ context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		repositories, err := r.listRepositories()
+		if err != nil {
+			yield("", err)
+			return
+		}
+
+		for _, repository := range repositories {
+			if !yield(repository, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (r *Registry) listRepositories() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: ListRepositories})
+	if err := r.errorFor(ListRepositories, ""); err != nil {
+		return nil, err
+	}
+
+	repositories := make([]string, 0, len(r.repositories))
+	for repository := range r.repositories {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+	return repositories, nil
+}
+
+// GetRepository Returns the attributes set with SetRepositoryAttributes and SetRepositoryUpdated
+func (r *Registry) GetRepository(_ context.Context, repository string) (registry.Repository, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: GetRepository, Repository: repository})
+	if err := r.errorFor(GetRepository, repository); err != nil {
+		return registry.Repository{}, err
+	}
+
+	if _, ok := r.repositories[repository]; !ok {
+		return registry.Repository{}, &registry.Error{Kind: registry.ErrNotFound, Message: fmt.Sprintf("repository %s", repository)}
+	}
+
+	return registry.Repository{
+		Name:                 repository,
+		LastUpdateTime:       r.updated[repository],
+		ManifestCount:        len(r.repositories[repository]),
+		ChangeableAttributes: r.attributes[repository],
+	}, nil
+}
+
+// ListManifests Lists manifests sorted by timestamp asc
+func (r *Registry) ListManifests(_ context.Context, repository string) iter.Seq2[manifest.Data, error] {
+	return func(yield func(manifest.Data, error) bool) {
+		manifests, err := r.listManifests(repository)
+		if err != nil {
+			yield(manifest.Data{}, err)
+			return
+		}
+
+		for _, m := range manifests {
+			if !yield(m, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (r *Registry) listManifests(repository string) ([]manifest.Data, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: ListManifests, Repository: repository})
+	if err := r.errorFor(ListManifests, repository); err != nil {
+		return nil, err
+	}
+
+	manifests, ok := r.repositories[repository]
+	if !ok {
+		return nil, &registry.Error{Kind: registry.ErrNotFound, Message: fmt.Sprintf("repository %s", repository)}
+	}
+
+	sorted := slices.Clone(manifests)
+	manifest.SortByLastUpdateTime(sorted)
+	return sorted, nil
+}
+
+// ListIndexChildren Returns the children given to AddIndex
+func (r *Registry) ListIndexChildren(_ context.Context, repository, digest string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: ListIndexChildren, Repository: repository, Digest: digest})
+	if err := r.errorFor(ListIndexChildren, repository); err != nil {
+		return nil, err
+	}
+
+	children, ok := r.children[childrenKey(repository, digest)]
+	if !ok {
+		return nil, &registry.Error{Kind: registry.ErrNotFound, Message: fmt.Sprintf("index %s in repository %s", digest, repository)}
+	}
+	return slices.Clone(children), nil
+}
+
+// ListReferrers Returns the referrers given to AddReferrers
+func (r *Registry) ListReferrers(_ context.Context, repository, digest string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: ListReferrers, Repository: repository, Digest: digest})
+	if err := r.errorFor(ListReferrers, repository); err != nil {
+		return nil, err
+	}
+
+	return slices.Clone(r.referrers[childrenKey(repository, digest)]), nil
+}
+
+// DeleteManifest Removes the manifest with the same digest, unless it or its repository is locked
+func (r *Registry) DeleteManifest(_ context.Context, repository string, m manifest.Data) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: DeleteManifest, Repository: repository, Digest: m.Digest})
+	if err := r.errorFor(DeleteManifest, repository); err != nil {
+		return err
+	}
+
+	manifests := r.repositories[repository]
+	index := slices.IndexFunc(manifests, func(existing manifest.Data) bool { return existing.Digest == m.Digest })
+	if index < 0 {
+		return &registry.Error{Kind: registry.ErrNotFound, Message: fmt.Sprintf("manifest %s in repository %s", m.Digest, repository)}
+	}
+	if manifests[index].IsLocked() || r.attributes[repository].IsLocked() {
+		return &registry.Error{Kind: registry.ErrLocked, Message: fmt.Sprintf("manifest %s in repository %s", m.Digest, repository)}
+	}
+
+	r.repositories[repository] = slices.Delete(manifests, index, index+1)
+	return nil
+}
+
+// Untag Removes tag from the manifest holding it
+func (r *Registry) Untag(_ context.Context, repository, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: Untag, Repository: repository, Tag: tag})
+	if err := r.errorFor(Untag, repository); err != nil {
+		return err
+	}
+
+	for i, m := range r.repositories[repository] {
+		if index := slices.Index(m.Tags, tag); index >= 0 {
+			r.repositories[repository][i].Tags = slices.Delete(slices.Clone(m.Tags), index, index+1)
+			return nil
+		}
+	}
+
+	return &registry.Error{Kind: registry.ErrNotFound, Message: fmt.Sprintf("tag %s in repository %s", tag, repository)}
+}
+
+// DeleteRepository Removes a repository with all its manifests, unless it is locked
+func (r *Registry) DeleteRepository(_ context.Context, repository string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: DeleteRepository, Repository: repository})
+	if err := r.errorFor(DeleteRepository, repository); err != nil {
+		return err
+	}
+
+	if _, ok := r.repositories[repository]; !ok {
+		return &registry.Error{Kind: registry.ErrNotFound, Message: fmt.Sprintf("repository %s", repository)}
+	}
+	if r.attributes[repository].IsLocked() {
+		return &registry.Error{Kind: registry.ErrLocked, Message: fmt.Sprintf("repository %s", repository)}
+	}
+
+	delete(r.repositories, repository)
+	return nil
+}
+
+func (r *Registry) errorFor(method, repository string) error {
+	if err, ok := r.errors[errorKey(method, repository)]; ok {
+		return err
+	}
+	return r.errors[errorKey(method, "")]
+}
+
+func errorKey(method, repository string) string {
+	return method + "/" + repository
+}
+
+func childrenKey(repository, digest string) string {
+	return repository + "@" + digest
+}
@@ -0,0 +1,35 @@
+package registry
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+const maxErrorBodySize = 4096
+
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// NextLink Extracts the path and query of the rel="next" entry in a Link header
+func NextLink(header string) string {
+	match := linkNextPattern.FindStringSubmatch(header)
+	if match == nil {
+		return ""
+	}
+
+	next, err := url.Parse(match[1])
+	if err != nil {
+		return ""
+	}
+
+	return next.RequestURI()
+}
+
+// Do Sends a request, classifying transport failures other than cancellation as ErrUnavailable
+func Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil && req.Context().Err() == nil {
+		return nil, NetworkError(err)
+	}
+	return resp, err
+}
@@ -0,0 +1,13 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextLink(t *testing.T) {
+	assert.Equal(t, "/acr/v1/_catalog?last=b&n=2", NextLink(`</acr/v1/_catalog?last=b&n=2>; rel="next"`))
+	assert.Equal(t, "/v2/_catalog?last=b", NextLink(`<https://host/v2/_catalog?last=b>; rel=next`))
+	assert.Equal(t, "", NextLink(""))
+}
@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"context"
+	"iter"
+	"time"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/manifest"
+)
+
+// Registry Operations the cleanup needs from a container registry
+//
+// Listings are iterators fetching one page at a time. An error ends the iteration
+type Registry interface {
+	// ListRepositories Lists all repositories in the registry
+	ListRepositories(ctx context.Context) iter.Seq2[string, error]
+	// GetRepository Gets the attributes of a single repository
+	GetRepository(ctx context.Context, repository string) (Repository, error)
+	// ListManifests Lists all manifests in a repository, sorted by timestamp asc
+	ListManifests(ctx context.Context, repository string) iter.Seq2[manifest.Data, error]
+	// ListIndexChildren Lists the digests of the manifests referenced by an image index
+	ListIndexChildren(ctx context.Context, repository, digest string) ([]string, error)
+	// ListReferrers Lists the digests of the manifests, such as signatures and SBOMs, attached to a subject
+	ListReferrers(ctx context.Context, repository, digest string) ([]string, error)
+	// DeleteManifest Deletes a manifest and all its tags
+	DeleteManifest(ctx context.Context, repository string, manifest manifest.Data) error
+	// Untag Removes a single tag, leaving the manifest in place
+	Untag(ctx context.Context, repository, tag string) error
+	// DeleteRepository Deletes a repository. Registries not supporting it return an error wrapping errors.ErrUnsupported
+	DeleteRepository(ctx context.Context, repository string) error
+}
+
+// Repository Attributes of a repository. Registries not supporting locks leave ChangeableAttributes empty,
+// and registries not tracking updates leave LastUpdateTime and ManifestCount zero
+type Repository struct {
+	Name                 string
+	LastUpdateTime       time.Time
+	ManifestCount        int
+	ChangeableAttributes manifest.ChangeableAttributes
+}
+
+// Collect Reads all items of a listing into a slice
+func Collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	items := make([]T, 0)
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
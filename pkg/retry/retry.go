@@ -0,0 +1,162 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ReasonNetwork Reason passed to Transport.OnRetry for failures without a status code
+const ReasonNetwork = "network"
+
+// Policy Controls how many times and how fast failed requests are retried
+type Policy struct {
+	// MaxAttempts Total number of attempts, including the first. Values below 2 disable retries
+	MaxAttempts int
+	// InitialBackoff Wait before the first retry, doubled for each following retry
+	InitialBackoff time.Duration
+	// MaxBackoff Upper bound of the wait between attempts, also applied to Retry-After
+	MaxBackoff time.Duration
+	// Jitter Fraction of the backoff randomly added or subtracted, between 0 and 1
+	Jitter float64
+}
+
+// DefaultPolicy Policy used if none is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff Returns the wait before retry number attempt, starting at 1
+func (p Policy) Backoff(attempt int, random func() float64) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	backoff = math.Min(backoff, float64(p.MaxBackoff))
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*random() - 1)
+	}
+	return time.Duration(math.Max(backoff, 0))
+}
+
+// IsRetryableStatus Indicates if a response status is transient. 429 and 5xx are retried,
+// while other statuses such as 401, 403 and 404 are permanent
+func IsRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// Transport http.RoundTripper retrying transient failures according to Policy
+type Transport struct {
+	Base   http.RoundTripper
+	Policy Policy
+	// OnRetry Called before each retry with the failed request, the retry number and the reason,
+	// which is the status code or ReasonNetwork
+	OnRetry func(req *http.Request, attempt int, reason string)
+
+	random func() float64
+}
+
+// NewTransport Constructor for Transport wrapping base
+func NewTransport(base http.RoundTripper, policy Policy) *Transport {
+	return &Transport{Base: base, Policy: policy, random: rand.Float64}
+}
+
+// RoundTrip Sends the request, retrying network errors and retryable statuses
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.Base.RoundTrip(req)
+
+		reason, retryable := t.classify(req, resp, err)
+		if !retryable || attempt >= t.Policy.MaxAttempts || !canReplay(req) {
+			return resp, err
+		}
+
+		wait := t.Policy.Backoff(attempt, t.randomFunc())
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = min(retryAfter, t.Policy.MaxBackoff)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if t.OnRetry != nil {
+			t.OnRetry(req, attempt, reason)
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) classify(req *http.Request, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		if req.Context().Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return "", false
+		}
+		return ReasonNetwork, true
+	}
+	return strconv.Itoa(resp.StatusCode), IsRetryableStatus(resp.StatusCode)
+}
+
+func (t *Transport) randomFunc() func() float64 {
+	if t.random == nil {
+		return rand.Float64
+	}
+	return t.random
+}
+
+func canReplay(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind Returns a copy of the request with a fresh body
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+// parseRetryAfter Parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,138 @@
+package retry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPolicy() Policy {
+	return Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+}
+
+func newTestClient(policy Policy, reasons *[]string) *http.Client {
+	transport := NewTransport(http.DefaultTransport, policy)
+	transport.OnRetry = func(_ *http.Request, _ int, reason string) {
+		*reasons = append(*reasons, reason)
+	}
+	return &http.Client{Transport: transport}
+}
+
+func TestRetriesTransientStatuses(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	var reasons []string
+	resp, err := newTestClient(testPolicy(), &reasons).Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"503", "429"}, reasons)
+}
+
+func TestDoesNotRetryPermanentStatuses(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(status)
+		}))
+
+		var reasons []string
+		resp, err := newTestClient(testPolicy(), &reasons).Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode)
+		assert.Equal(t, int32(1), calls.Load())
+		assert.Empty(t, reasons)
+		server.Close()
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	var reasons []string
+	resp, err := newTestClient(testPolicy(), &reasons).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Len(t, reasons, 2)
+}
+
+func TestReplaysRequestBody(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var reasons []string
+	resp, err := newTestClient(testPolicy(), &reasons).Post(server.URL, "text/plain", strings.NewReader("grant_type=x"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"grant_type=x", "grant_type=x"}, bodies)
+}
+
+func TestRetriesNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	var reasons []string
+	_, err := newTestClient(testPolicy(), &reasons).Get(url)
+	assert.Error(t, err)
+	assert.Equal(t, []string{ReasonNetwork, ReasonNetwork}, reasons)
+}
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: 0.5}
+	noJitter := func() float64 { return 0.5 }
+	assert.Equal(t, time.Second, policy.Backoff(1, noJitter))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2, noJitter))
+	assert.Equal(t, 4*time.Second, policy.Backoff(3, noJitter))
+	assert.Equal(t, 5*time.Second, policy.Backoff(4, noJitter))
+	assert.Equal(t, 1500*time.Millisecond, policy.Backoff(1, func() float64 { return 1 }))
+	assert.Equal(t, 500*time.Millisecond, policy.Backoff(1, func() float64 { return 0 }))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	d, ok := parseRetryAfter("7")
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, d)
+
+	d, ok = parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute, d, float64(2*time.Second))
+
+	_, ok = parseRetryAfter("")
+	assert.False(t, ok)
+}
@@ -0,0 +1,127 @@
+package semver
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Version A semantic version. Build metadata is dropped, as it does not affect precedence
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease string
+}
+
+var versionPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+// Parse Parses a tag such as 1.2.3, v1.2.3 or v1.2.3-rc.1
+func Parse(tag string) (Version, bool) {
+	match := versionPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return Version{}, false
+	}
+
+	var version Version
+	var err error
+	if version.Major, err = strconv.ParseUint(match[1], 10, 64); err != nil {
+		return Version{}, false
+	}
+	if version.Minor, err = strconv.ParseUint(match[2], 10, 64); err != nil {
+		return Version{}, false
+	}
+	if version.Patch, err = strconv.ParseUint(match[3], 10, 64); err != nil {
+		return Version{}, false
+	}
+	version.Prerelease = match[4]
+	return version, true
+}
+
+// String Formats the version without v prefix
+func (version Version) String() string {
+	if len(version.Prerelease) > 0 {
+		return fmt.Sprintf("%d.%d.%d-%s", version.Major, version.Minor, version.Patch, version.Prerelease)
+	}
+	return fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Patch)
+}
+
+// Compare Returns -1, 0 or 1 as version has lower, equal or higher precedence than other
+func (version Version) Compare(other Version) int {
+	if c := cmp.Compare(version.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(version.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(version.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(version.Prerelease, other.Prerelease)
+}
+
+// comparePrerelease Compares prereleases by their dot separated identifiers. A version without prerelease has higher precedence
+func comparePrerelease(prerelease, other string) int {
+	switch {
+	case prerelease == other:
+		return 0
+	case len(prerelease) == 0:
+		return 1
+	case len(other) == 0:
+		return -1
+	}
+
+	identifiers, otherIdentifiers := strings.Split(prerelease, "."), strings.Split(other, ".")
+	for i := 0; i < len(identifiers) && i < len(otherIdentifiers); i++ {
+		number, numErr := strconv.ParseUint(identifiers[i], 10, 64)
+		otherNumber, otherNumErr := strconv.ParseUint(otherIdentifiers[i], 10, 64)
+		var c int
+		switch {
+		case numErr == nil && otherNumErr == nil:
+			c = cmp.Compare(number, otherNumber)
+		case numErr == nil:
+			c = -1
+		case otherNumErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(identifiers[i], otherIdentifiers[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(identifiers), len(otherIdentifiers))
+}
+
+// Retention Keeps the newest patch versions of each minor, within the newest minors of each major.
+// Prereleases count as patch versions of their minor
+type Retention struct {
+	PatchesPerMinor int
+	MinorsPerMajor  int
+}
+
+// Keep Returns the versions to retain
+func (retention Retention) Keep(versions []Version) map[Version]bool {
+	sorted := slices.Clone(versions)
+	slices.SortFunc(sorted, func(a, b Version) int { return b.Compare(a) })
+	sorted = slices.Compact(sorted)
+
+	type minor struct{ major, minor uint64 }
+	minorsOfMajor := make(map[uint64]int)
+	patchesOfMinor := make(map[minor]int)
+	kept := make(map[Version]bool)
+	for _, version := range sorted {
+		key := minor{version.Major, version.Minor}
+		if _, seen := patchesOfMinor[key]; !seen {
+			minorsOfMajor[version.Major]++
+		}
+		patchesOfMinor[key]++
+		if minorsOfMajor[version.Major] <= retention.MinorsPerMajor && patchesOfMinor[key] <= retention.PatchesPerMinor {
+			kept[version] = true
+		}
+	}
+	return kept
+}
@@ -0,0 +1,55 @@
+package semver
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for tag, expected := range map[string]Version{
+		"1.2.3":            {Major: 1, Minor: 2, Patch: 3},
+		"v1.2.3":           {Major: 1, Minor: 2, Patch: 3},
+		"v10.0.12-rc.1":    {Major: 10, Minor: 0, Patch: 12, Prerelease: "rc.1"},
+		"v1.2.3+build.5":   {Major: 1, Minor: 2, Patch: 3},
+		"1.2.3-beta+linux": {Major: 1, Minor: 2, Patch: 3, Prerelease: "beta"},
+	} {
+		version, ok := Parse(tag)
+		assert.True(t, ok, tag)
+		assert.Equal(t, expected, version, tag)
+	}
+
+	for _, tag := range []string{"latest", "v1.2", "1.2.3.4", "01.2.3", "v1.2.3-", "development-1.2.3", "V1.2.3", "99999999999999999999.0.0"} {
+		_, ok := Parse(tag)
+		assert.False(t, ok, tag)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"0.9.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0"}
+	for i := 1; i < len(ordered); i++ {
+		lower, _ := Parse(ordered[i-1])
+		higher, _ := Parse(ordered[i])
+		assert.Equal(t, -1, lower.Compare(higher), "%s < %s", lower, higher)
+		assert.Equal(t, 1, higher.Compare(lower), "%s > %s", higher, lower)
+		assert.Equal(t, 0, higher.Compare(higher))
+	}
+}
+
+func TestRetentionKeep(t *testing.T) {
+	var versions []Version
+	for _, tag := range []string{"1.0.0", "1.0.1", "1.1.0", "1.1.1", "1.1.2", "1.2.0", "1.2.1", "1.2.2-rc.1", "1.2.2", "1.2.2", "2.0.0", "0.1.0"} {
+		version, _ := Parse(tag)
+		versions = append(versions, version)
+	}
+
+	kept := Retention{PatchesPerMinor: 2, MinorsPerMajor: 2}.Keep(versions)
+
+	var keptVersions []string
+	for version := range kept {
+		keptVersions = append(keptVersions, version.String())
+	}
+	slices.Sort(keptVersions)
+	assert.Equal(t, []string{"0.1.0", "1.1.1", "1.1.2", "1.2.2", "1.2.2-rc.1", "2.0.0"}, keptVersions)
+}
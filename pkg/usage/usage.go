@@ -0,0 +1,335 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/image"
+	radixclient "github.com/equinor/radix-operator/pkg/client/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pageSize Number of objects requested in each list call
+const pageSize = 500
+
+// Names of the sources
+const (
+	NameRadixDeployments = "radixdeployments"
+	NamePods             = "pods"
+	NameDeployments      = "deployments"
+	NameStatefulSets     = "statefulsets"
+	NameDaemonSets       = "daemonsets"
+	NameCronJobs         = "cronjobs"
+	NameJobs             = "jobs"
+)
+
+// Names Names of all sources
+var Names = []string{NameRadixDeployments, NamePods, NameDeployments, NameStatefulSets, NameDaemonSets, NameCronJobs, NameJobs}
+
+// DefaultNames Names of the sources used unless others are given, the others are opt-in
+var DefaultNames = []string{NameRadixDeployments, NamePods}
+
+// Source Lists images in use in the cluster
+type Source interface {
+	// Name Name of the source, used in logs
+	Name() string
+	// Images Returns the images in use, in all namespaces
+	Images(ctx context.Context) ([]image.Data, error)
+}
+
+// New Returns the source with the name, one of Names
+func New(name string, kubeClient kubernetes.Interface, radixClient radixclient.Interface) (Source, error) {
+	switch name {
+	case NameRadixDeployments:
+		return RadixDeployments{Client: radixClient}, nil
+	case NamePods:
+		return Pods{Client: kubeClient}, nil
+	case NameDeployments:
+		return Deployments{Client: kubeClient}, nil
+	case NameStatefulSets:
+		return StatefulSets{Client: kubeClient}, nil
+	case NameDaemonSets:
+		return DaemonSets{Client: kubeClient}, nil
+	case NameCronJobs:
+		return CronJobs{Client: kubeClient}, nil
+	case NameJobs:
+		return Jobs{Client: kubeClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown usage source %s, options: %s", name, strings.Join(Names, ", "))
+	}
+}
+
+// RadixDeployments Images of the components and jobs of RadixDeployments
+type RadixDeployments struct {
+	Client radixclient.Interface
+}
+
+// Name Name of the source
+func (s RadixDeployments) Name() string {
+	return NameRadixDeployments
+}
+
+// Images Returns the images of the components and jobs of all RadixDeployments
+func (s RadixDeployments) Images(ctx context.Context) ([]image.Data, error) {
+	var images []image.Data
+	err := list(ctx, func(ctx context.Context, options metav1.ListOptions) (string, error) {
+		rds, err := s.Client.RadixV1().RadixDeployments(corev1.NamespaceAll).List(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, rd := range rds.Items {
+			for _, component := range rd.Spec.Components {
+				images = appendImage(images, component.Image)
+			}
+			for _, job := range rd.Spec.Jobs {
+				images = appendImage(images, job.Image)
+			}
+		}
+		return rds.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list radixdeployments failed: %w", err)
+	}
+	return images, nil
+}
+
+// Pods Images of the containers of running pods, including the digests they were pulled by
+type Pods struct {
+	Client kubernetes.Interface
+}
+
+// Name Name of the source
+func (s Pods) Name() string {
+	return NamePods
+}
+
+// Images Returns the images of the containers, init containers and ephemeral containers of all pods,
+// and the digests in the image ids of their container statuses
+func (s Pods) Images(ctx context.Context) ([]image.Data, error) {
+	var images []image.Data
+	err := list(ctx, func(ctx context.Context, options metav1.ListOptions) (string, error) {
+		pods, err := s.Client.CoreV1().Pods(corev1.NamespaceAll).List(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, pod := range pods.Items {
+			images = appendPodSpecImages(images, pod.Spec)
+			images = appendPodStatusImages(images, pod.Status)
+		}
+		return pods.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list pods failed: %w", err)
+	}
+	return images, nil
+}
+
+// Deployments Images of the pod templates of Deployments, also when scaled to zero
+type Deployments struct {
+	Client kubernetes.Interface
+}
+
+// Name Name of the source
+func (s Deployments) Name() string {
+	return NameDeployments
+}
+
+// Images Returns the images of the pod templates of all Deployments
+func (s Deployments) Images(ctx context.Context) ([]image.Data, error) {
+	var images []image.Data
+	err := list(ctx, func(ctx context.Context, options metav1.ListOptions) (string, error) {
+		deployments, err := s.Client.AppsV1().Deployments(corev1.NamespaceAll).List(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, deployment := range deployments.Items {
+			images = appendPodSpecImages(images, deployment.Spec.Template.Spec)
+		}
+		return deployments.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list deployments failed: %w", err)
+	}
+	return images, nil
+}
+
+// StatefulSets Images of the pod templates of StatefulSets
+type StatefulSets struct {
+	Client kubernetes.Interface
+}
+
+// Name Name of the source
+func (s StatefulSets) Name() string {
+	return NameStatefulSets
+}
+
+// Images Returns the images of the pod templates of all StatefulSets
+func (s StatefulSets) Images(ctx context.Context) ([]image.Data, error) {
+	var images []image.Data
+	err := list(ctx, func(ctx context.Context, options metav1.ListOptions) (string, error) {
+		statefulSets, err := s.Client.AppsV1().StatefulSets(corev1.NamespaceAll).List(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, statefulSet := range statefulSets.Items {
+			images = appendPodSpecImages(images, statefulSet.Spec.Template.Spec)
+		}
+		return statefulSets.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list statefulsets failed: %w", err)
+	}
+	return images, nil
+}
+
+// DaemonSets Images of the pod templates of DaemonSets
+type DaemonSets struct {
+	Client kubernetes.Interface
+}
+
+// Name Name of the source
+func (s DaemonSets) Name() string {
+	return NameDaemonSets
+}
+
+// Images Returns the images of the pod templates of all DaemonSets
+func (s DaemonSets) Images(ctx context.Context) ([]image.Data, error) {
+	var images []image.Data
+	err := list(ctx, func(ctx context.Context, options metav1.ListOptions) (string, error) {
+		daemonSets, err := s.Client.AppsV1().DaemonSets(corev1.NamespaceAll).List(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, daemonSet := range daemonSets.Items {
+			images = appendPodSpecImages(images, daemonSet.Spec.Template.Spec)
+		}
+		return daemonSets.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list daemonsets failed: %w", err)
+	}
+	return images, nil
+}
+
+// CronJobs Images of the job templates of CronJobs. Suspended CronJobs are included, as they may be resumed
+type CronJobs struct {
+	Client kubernetes.Interface
+}
+
+// Name Name of the source
+func (s CronJobs) Name() string {
+	return NameCronJobs
+}
+
+// Images Returns the images of the job templates of all CronJobs
+func (s CronJobs) Images(ctx context.Context) ([]image.Data, error) {
+	var images []image.Data
+	err := list(ctx, func(ctx context.Context, options metav1.ListOptions) (string, error) {
+		cronJobs, err := s.Client.BatchV1().CronJobs(corev1.NamespaceAll).List(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, cronJob := range cronJobs.Items {
+			images = appendPodSpecImages(images, cronJob.Spec.JobTemplate.Spec.Template.Spec)
+		}
+		return cronJobs.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list cronjobs failed: %w", err)
+	}
+	return images, nil
+}
+
+// Jobs Images of the pod templates of Jobs, also when completed
+type Jobs struct {
+	Client kubernetes.Interface
+}
+
+// Name Name of the source
+func (s Jobs) Name() string {
+	return NameJobs
+}
+
+// Images Returns the images of the pod templates of all Jobs
+func (s Jobs) Images(ctx context.Context) ([]image.Data, error) {
+	var images []image.Data
+	err := list(ctx, func(ctx context.Context, options metav1.ListOptions) (string, error) {
+		jobs, err := s.Client.BatchV1().Jobs(corev1.NamespaceAll).List(ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, job := range jobs.Items {
+			images = appendPodSpecImages(images, job.Spec.Template.Spec)
+		}
+		return jobs.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list jobs failed: %w", err)
+	}
+	return images, nil
+}
+
+// list Calls listPage for each page of a list, until it returns no continue token
+func list(ctx context.Context, listPage func(ctx context.Context, options metav1.ListOptions) (string, error)) error {
+	options := metav1.ListOptions{Limit: pageSize}
+	for {
+		next, err := listPage(ctx, options)
+		if err != nil {
+			return err
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		options.Continue = next
+	}
+}
+
+// appendPodSpecImages Appends the images of all containers of a pod spec
+func appendPodSpecImages(images []image.Data, spec corev1.PodSpec) []image.Data {
+	for _, container := range spec.InitContainers {
+		images = appendImage(images, container.Image)
+	}
+	for _, container := range spec.Containers {
+		images = appendImage(images, container.Image)
+	}
+	for _, container := range spec.EphemeralContainers {
+		images = appendImage(images, container.Image)
+	}
+	return images
+}
+
+// appendPodStatusImages Appends the images pinned to the digests the containers of a pod were pulled by
+func appendPodStatusImages(images []image.Data, status corev1.PodStatus) []image.Data {
+	for _, statuses := range [][]corev1.ContainerStatus{status.InitContainerStatuses, status.ContainerStatuses, status.EphemeralContainerStatuses} {
+		for _, containerStatus := range statuses {
+			if pulled := imageOfImageID(containerStatus.ImageID); pulled != nil {
+				images = append(images, *pulled)
+			}
+		}
+	}
+	return images
+}
+
+// imageOfImageID Parses an image id such as docker-pullable://myacr.azurecr.io/app@sha256:...
+// Returns nil for ids without repository digest, such as the local image id sha256:...
+func imageOfImageID(imageID string) *image.Data {
+	if _, reference, found := strings.Cut(imageID, "://"); found {
+		imageID = reference
+	}
+	pulled := image.Parse(imageID)
+	if pulled == nil || len(pulled.Digest) == 0 {
+		return nil
+	}
+	return pulled
+}
+
+// appendImage Appends the image if it follows the reference grammar
+func appendImage(images []image.Data, reference string) []image.Data {
+	if parsed := image.Parse(reference); parsed != nil {
+		images = append(images, *parsed)
+	}
+	return images
+}
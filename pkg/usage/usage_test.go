@@ -0,0 +1,103 @@
+package usage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/equinor/radix-acr-cleanup/pkg/image"
+	radixv1 "github.com/equinor/radix-operator/pkg/apis/radix/v1"
+	radixfake "github.com/equinor/radix-operator/pkg/client/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+const digest = "sha256:7f8343822a17acc74c88c96badc2a1a981ad9fc749b4c5194816c0ef01fc9457"
+
+func TestRadixDeploymentsImages(t *testing.T) {
+	client := radixfake.NewSimpleClientset(&radixv1.RadixDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "app-dev"},
+		Spec: radixv1.RadixDeploymentSpec{
+			Components: []radixv1.RadixDeployComponent{{Image: "radixdev.azurecr.io/app-web:development-1"}, {Image: "Invalid"}},
+			Jobs:       []radixv1.RadixDeployJobComponent{{Image: "radixdev.azurecr.io/app-job:development-1"}},
+		},
+	})
+
+	images, err := RadixDeployments{Client: client}.Images(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []image.Data{
+		{Registry: "radixdev.azurecr.io", Repository: "app-web", Tag: "development-1"},
+		{Registry: "radixdev.azurecr.io", Repository: "app-job", Tag: "development-1"},
+	}, images)
+}
+
+func TestPodsImages(t *testing.T) {
+	client := kubefake.NewClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-web-1", Namespace: "app-dev"},
+		Spec: corev1.PodSpec{
+			InitContainers:      []corev1.Container{{Image: "radixdev.azurecr.io/init:1"}},
+			Containers:          []corev1.Container{{Image: "radixdev.azurecr.io/app-web:development-2"}},
+			EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Image: "busybox"}}},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{ImageID: "docker-pullable://radixdev.azurecr.io/init@" + digest}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{ImageID: "radixdev.azurecr.io/app-web@" + digest},
+				{ImageID: digest},
+				{ImageID: ""},
+			},
+		},
+	})
+
+	images, err := Pods{Client: client}.Images(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []image.Data{
+		{Registry: "radixdev.azurecr.io", Repository: "init", Tag: "1"},
+		{Registry: "radixdev.azurecr.io", Repository: "app-web", Tag: "development-2"},
+		{Registry: "docker.io", Repository: "library/busybox", Tag: "latest"},
+		{Registry: "radixdev.azurecr.io", Repository: "init", Digest: digest},
+		{Registry: "radixdev.azurecr.io", Repository: "app-web", Digest: digest},
+	}, images)
+}
+
+func TestWorkloadImages(t *testing.T) {
+	template := func(name string) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "radixdev.azurecr.io/" + name + ":1"}}}}
+	}
+	suspend := true
+	meta := metav1.ObjectMeta{Name: "workload", Namespace: "team"}
+	client := kubefake.NewClientset(
+		&appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{Template: template("deployment")}},
+		&appsv1.StatefulSet{ObjectMeta: meta, Spec: appsv1.StatefulSetSpec{Template: template("statefulset")}},
+		&appsv1.DaemonSet{ObjectMeta: meta, Spec: appsv1.DaemonSetSpec{Template: template("daemonset")}},
+		&batchv1.CronJob{ObjectMeta: meta, Spec: batchv1.CronJobSpec{Suspend: &suspend, JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: template("cronjob")}}}},
+		&batchv1.Job{ObjectMeta: meta, Spec: batchv1.JobSpec{Template: template("job")}},
+	)
+
+	for name, source := range map[string]Source{
+		"deployment":  Deployments{Client: client},
+		"statefulset": StatefulSets{Client: client},
+		"daemonset":   DaemonSets{Client: client},
+		"cronjob":     CronJobs{Client: client},
+		"job":         Jobs{Client: client},
+	} {
+		images, err := source.Images(context.Background())
+		require.NoError(t, err, source.Name())
+		assert.Equal(t, []image.Data{{Registry: "radixdev.azurecr.io", Repository: name, Tag: "1"}}, images, source.Name())
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range Names {
+		source, err := New(name, kubefake.NewClientset(), radixfake.NewSimpleClientset())
+		require.NoError(t, err, name)
+		assert.Equal(t, name, source.Name())
+	}
+
+	_, err := New("replicasets", kubefake.NewClientset(), radixfake.NewSimpleClientset())
+	assert.Error(t, err)
+}